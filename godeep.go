@@ -1,12 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/spf13/cobra"
 
+	"github.com/sarange/godeep/pkg/stego"
 	"github.com/sarange/godeep/utils"
 )
 
@@ -22,28 +28,76 @@ func main() {
 	}
 
 	// Define flags for the root command (applies to the whole program)
-	var inputFile string
+	var inputFiles []string
 	var outputFile string
-	var container string
+	var containers []string
 	var password string
 	var verbose bool
 	var noEncryption bool
+	var depth int
+	var legacyLayout bool
+	var noPreserve bool
+	var list bool
+	var jsonOutput bool
+	var scatter bool
+	var kdfName string
+	var kdfTime uint32
+	var kdfMemory string
+	var kdfThreads uint8
+	var checksumName string
+	var fec int
+	var sync bool
+	var threshold int
+	var recipientKeys []string
+	var identityKeys []string
+	var signKey string
+	var signerKeys []string
+	var signing bool
+	var hiddenFiles []string
+	var hiddenPassword string
+	var stealth bool
+	var padding string
+	var cipherName string
 
 	// Root command flags
-	rootCmd.PersistentFlags().StringVarP(&inputFile, "input", "i", "", "Path to the input WAV file")
-	rootCmd.PersistentFlags().StringVarP(&outputFile, "output", "o", "", "Path to the output WAV file or extracted file")
-	rootCmd.PersistentFlags().StringVarP(&container, "container", "c", "", "WAV Container to embed the data within")
+	rootCmd.PersistentFlags().StringArrayVarP(&inputFiles, "input", "i", nil, "Files or directories to embed (repeat the flag or list them after it)")
+	rootCmd.PersistentFlags().StringVarP(&outputFile, "output", "o", "", "Path to the output WAV file, or the extracted file or a directory to extract into")
+	rootCmd.PersistentFlags().StringArrayVarP(&containers, "container", "c", nil, "WAV Container to embed the data within (repeat the flag or give a directory to split the data across several)")
 	rootCmd.PersistentFlags().StringVarP(&password, "password", "p", "", "Encryption password (required unless --noencryption is used)")
 	rootCmd.PersistentFlags().BoolVarP(&noEncryption, "noencryption", "", false, "Disable encryption")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
+	rootCmd.PersistentFlags().IntVarP(&depth, "depth", "d", 0, "Number of LSBs used per sample, 1-4 (embed defaults to 1, extract reads it from the container)")
+	rootCmd.PersistentFlags().BoolVarP(&scatter, "scatter", "", false, "Scatter the data across the whole container at password-derived positions")
+	rootCmd.PersistentFlags().StringVarP(&kdfName, "kdf", "", "pbkdf2", "Key derivation function for encryption: pbkdf2, argon2id or scrypt (--hidden and --stealth masks always use argon2id)")
+	rootCmd.PersistentFlags().Uint32VarP(&kdfTime, "kdf-time", "", 0, "KDF time cost: iterations for pbkdf2, passes for argon2id (default depends on --kdf)")
+	rootCmd.PersistentFlags().StringVarP(&kdfMemory, "kdf-memory", "", "", "KDF memory cost for argon2id and scrypt, e.g. 256MiB (default depends on --kdf)")
+	rootCmd.PersistentFlags().Uint8VarP(&kdfThreads, "kdf-threads", "", 0, "KDF parallelism for argon2id and scrypt (default depends on --kdf)")
+	rootCmd.PersistentFlags().StringVarP(&cipherName, "cipher", "", "aes-gcm", "Cipher for encryption: aes-gcm or xchacha20 (XChaCha20-Poly1305)")
+	rootCmd.PersistentFlags().StringVarP(&checksumName, "checksum", "", "sha256", "Digest of the hidden data stored for integrity checks: sha256, blake2b or none")
+	rootCmd.PersistentFlags().IntVarP(&fec, "fec", "", 0, "Reed-Solomon parity bytes per 255-byte block for error correction, 2-128 (0 disables)")
+	rootCmd.PersistentFlags().BoolVarP(&sync, "sync", "", false, "Write the data in sync blocks that can still be found in a trimmed or spliced file")
+	rootCmd.PersistentFlags().IntVarP(&threshold, "threshold", "", 0, "Share the encryption key across the containers so that any N of them recover it, without a password unless -p is given")
+	rootCmd.PersistentFlags().StringArrayVarP(&recipientKeys, "recipient", "r", nil, "Encrypt to an X25519 public key, or a file of them, instead of a password (repeat for several)")
+	rootCmd.PersistentFlags().StringArrayVarP(&identityKeys, "identity", "", nil, "X25519 private key, or a file of them, to decrypt data encrypted to recipients")
+	rootCmd.PersistentFlags().StringVarP(&signKey, "sign-key", "", "", "Ed25519 signing key, or a file holding it, to sign the embedded data with")
+	rootCmd.PersistentFlags().StringArrayVarP(&signerKeys, "signer-pubkey", "", nil, "Ed25519 public key, or a file of them, the hidden data must be signed by (repeat for several)")
+	rootCmd.PersistentFlags().StringArrayVarP(&hiddenFiles, "hidden", "", nil, "Files or directories to hide behind the embedded ones, which become a decoy (needs --hidden-password)")
+	rootCmd.PersistentFlags().StringVarP(&hiddenPassword, "hidden-password", "", "", "Password of the files given with --hidden, different from the decoy's")
+	rootCmd.PersistentFlags().BoolVarP(&stealth, "stealth", "", false, "Mask the whole hidden data with the password and leave no layout byte, so nothing marks the file")
+	rootCmd.PersistentFlags().StringVarP(&padding, "pad", "", "", "Pad the hidden data to a multiple of a size such as 64K, or 'fill' to use the whole container, so its length is hidden")
+	rootCmd.PersistentFlags().BoolVarP(&noPreserve, "no-preserve", "", false, "Do not restore the extracted file's original permissions and modification time")
+	rootCmd.PersistentFlags().BoolVarP(&list, "list", "l", false, "List the hidden files instead of extracting them")
+	rootCmd.PersistentFlags().BoolVarP(&jsonOutput, "json", "", false, "Print reports as JSON")
+	rootCmd.PersistentFlags().BoolVarP(&legacyLayout, "legacy-layout", "", false, "Extract from containers made by older versions that used every PCM byte")
 
 	// Define the "embed" command
 	var embedCmd = &cobra.Command{
 		Use:   "embed",
 		Short: "Embed data into a WAV file",
 		Run: func(cmd *cobra.Command, args []string) {
-			// Validate Required Inputs for "embed" command
-			if inputFile == "" {
+			// Validate Required Inputs for "embed" command, paths after the flags are inputs too
+			inputFiles = append(inputFiles, args...)
+			if len(inputFiles) == 0 {
 				fmt.Println("Error: Input file path is required for embedding.")
 				cmd.Usage()
 				os.Exit(1)
@@ -55,33 +109,215 @@ func main() {
 				os.Exit(1)
 			}
 
-			if container == "" {
+			container, err := expandContainers(containers)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			if len(container) == 0 {
 				fmt.Println("Error: Container WAV file is required for embedding.")
 				cmd.Usage()
 				os.Exit(1)
 			}
 
-			// Check Password Requirement for embedding (when encryption is not disabled)
-			if password == "" && !noEncryption {
+			if threshold != 0 {
+				if noEncryption {
+					fmt.Println("Error: --threshold shares the encryption key and cannot be combined with --noencryption.")
+					cmd.Usage()
+					os.Exit(1)
+				}
+				if err := stego.ValidateThreshold(threshold, len(container)); err != nil {
+					fmt.Println("Error:", err)
+					cmd.Usage()
+					os.Exit(1)
+				}
+			}
+
+			recipients, err := readRecipients(recipientKeys)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			if recipients != nil && (noEncryption || threshold != 0) {
+				fmt.Println("Error: --recipient cannot be combined with --noencryption or --threshold.")
+				cmd.Usage()
+				os.Exit(1)
+			}
+
+			if hiddenFiles != nil {
+				if len(container) != 1 || threshold != 0 || recipients != nil || noEncryption || password == "" {
+					fmt.Println("Error: --hidden needs a single container and a password-encrypted decoy, without --threshold or --recipient.")
+					cmd.Usage()
+					os.Exit(1)
+				}
+				if hiddenPassword == "" || hiddenPassword == password {
+					fmt.Println("Error: --hidden needs a --hidden-password different from the decoy's password.")
+					cmd.Usage()
+					os.Exit(1)
+				}
+				if scatter || sync {
+					fmt.Println("Error: --hidden cannot be combined with --scatter or --sync.")
+					cmd.Usage()
+					os.Exit(1)
+				}
+			}
+
+			if stealth {
+				if password == "" {
+					fmt.Println("Error: Password is required for --stealth.")
+					cmd.Usage()
+					os.Exit(1)
+				}
+				if len(container) != 1 || hiddenFiles != nil || scatter || sync || fec != 0 {
+					fmt.Println("Error: --stealth needs a single container and cannot be combined with --hidden, --scatter, --sync or --fec.")
+					cmd.Usage()
+					os.Exit(1)
+				}
+			}
+
+			var signingKey *stego.SigningKey
+			if signKey != "" {
+				signingKey, err = readSigningKey(signKey)
+				if err != nil {
+					fmt.Println("Error:", err)
+					os.Exit(1)
+				}
+			}
+
+			// Check Password Requirement for embedding (when encryption is not disabled), shared keys and recipients need none
+			if password == "" && !noEncryption && threshold == 0 && recipients == nil {
 				fmt.Println("Error: Password is required for encryption when --noencryption is not used.")
 				cmd.Usage()
 				os.Exit(1)
 			}
 
+			if scatter && sync {
+				fmt.Println("Error: --scatter and --sync cannot be combined.")
+				cmd.Usage()
+				os.Exit(1)
+			}
+
+			if scatter && password == "" {
+				fmt.Println("Error: Password is required for --scatter.")
+				cmd.Usage()
+				os.Exit(1)
+			}
+
+			if depth == 0 {
+				depth = stego.MinDepth
+			}
+
+			// Start from the chosen KDF's defaults and apply any cost overrides
+			kdf, err := stego.NewKDFParams(kdfName)
+			if err != nil {
+				fmt.Println("Error:", err)
+				cmd.Usage()
+				os.Exit(1)
+			}
+			if kdfTime != 0 {
+				kdf.Time = kdfTime
+			}
+			if kdfMemory != "" {
+				kdf.Memory, err = stego.ParseMemorySize(kdfMemory)
+				if err != nil {
+					fmt.Println("Error:", err)
+					cmd.Usage()
+					os.Exit(1)
+				}
+			}
+			if kdfThreads != 0 {
+				kdf.Threads = kdfThreads
+			}
+			cipherAlgorithm, err := stego.ParseCipher(cipherName)
+			if err != nil {
+				fmt.Println("Error:", err)
+				cmd.Usage()
+				os.Exit(1)
+			}
+			if noEncryption && cipherAlgorithm != stego.CipherAESGCM {
+				fmt.Println("Error: --cipher cannot be combined with --noencryption.")
+				cmd.Usage()
+				os.Exit(1)
+			}
+			checksum, err := stego.ParseChecksum(checksumName)
+			if err != nil {
+				fmt.Println("Error:", err)
+				cmd.Usage()
+				os.Exit(1)
+			}
+			padSize, err := stego.ParsePadding(padding)
+			if err != nil {
+				fmt.Println("Error:", err)
+				cmd.Usage()
+				os.Exit(1)
+			}
+			if padSize == stego.PadFill && (len(container) != 1 || hiddenFiles != nil) {
+				fmt.Println("Error: --pad fill needs a single container and cannot be combined with --hidden, use a size instead.")
+				cmd.Usage()
+				os.Exit(1)
+			}
+			if err := stego.ValidateDepth(depth); err != nil {
+				fmt.Println("Error:", err)
+				cmd.Usage()
+				os.Exit(1)
+			}
+			if fec != 0 {
+				if err := stego.ValidateFEC(fec); err != nil {
+					fmt.Println("Error:", err)
+					cmd.Usage()
+					os.Exit(1)
+				}
+			}
+
 			// If validation passed, print out the parameters and proceed with the embed logic
-			fmt.Printf("Embedding data from '%s' into '%s' (container: '%s', encryption: %v)\n", inputFile, outputFile, container, !noEncryption)
+			fmt.Printf("Embedding data from '%s' into '%s' (container: '%s', encryption: %v)\n", strings.Join(inputFiles, "', '"), outputFile, strings.Join(container, "', '"), !noEncryption)
 
-			var key []byte
-			if !noEncryption {
-				key = utils.DeriveKey(password)
-				if verbose {
-					fmt.Println("[DEBUG] Encryption disabled.")
+			if verbose && noEncryption {
+				fmt.Println("[DEBUG] Encryption disabled.")
+			}
+
+			// The key is derived inside Embed, with a fresh salt stored in the GDP header
+			opts := stego.EmbedOptions{
+				Password:   password,
+				Encryption: !noEncryption,
+				KDF:        kdf,
+				Cipher:     cipherAlgorithm,
+				Depth:      depth,
+				Scatter:    scatter,
+				Checksum:   checksum,
+				FEC:        fec,
+				Sync:       sync,
+				Stealth:    stealth,
+				Recipients: recipients,
+				SignKey:    signingKey,
+				Padding:    padSize,
+				Logf:       debugLogger(verbose),
+			}
+			if hiddenFiles != nil {
+				err = stego.EmbedHidden(inputFiles, hiddenFiles, outputFile, container[0], hiddenPassword, opts)
+			} else if len(container) == 1 {
+				err = stego.Embed(inputFiles, outputFile, container[0], opts)
+			} else {
+				// A set is written into the output directory under the containers' names
+				var outputs []string
+				if err = os.MkdirAll(outputFile, 0755); err == nil {
+					outputs, err = stego.SetOutputs(outputFile, container)
+				}
+				switch {
+				case err != nil:
+				case threshold != 0:
+					err = stego.EmbedShares(inputFiles, outputs, container, threshold, opts)
+					if err == nil {
+						fmt.Printf("Key shared across %d containers, any %d of them recover the payload\n", len(container), threshold)
+					}
+				default:
+					var needed int
+					needed, err = stego.EmbedSet(inputFiles, outputs, container, opts)
+					if err == nil {
+						fmt.Printf("Split across %d containers, the payload needs at least %d of them\n", len(container), needed)
+					}
 				}
-			} else if verbose {
-				fmt.Println("[DEBUG] Encryption enabled. Deriving key...")
 			}
-		
-			err := utils.Embed(inputFile, outputFile, container, key, !noEncryption, verbose)
 
 			if err != nil {
 				fmt.Println("Error embeding:", err)
@@ -99,48 +335,332 @@ func main() {
 		Short: "Extract data from a WAV file",
 		Run: func(cmd *cobra.Command, args []string) {
 			// Validate Required Inputs for "extract" command
-			if container == "" {
-				fmt.Println("Error: Container WAV file is required for embedding.")
+			container, err := expandContainers(containers)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			if len(container) == 0 {
+				fmt.Println("Error: Container WAV file is required for extraction.")
 				cmd.Usage()
 				os.Exit(1)
 			}
 
-			if outputFile == "" {
+			if outputFile == "" && !list {
 				fmt.Println("Error: Output file path is required for extraction.")
 				cmd.Usage()
 				os.Exit(1)
 			}
 
-			// Check Password Requirement for extraction (when encryption is not disabled)
-			if password == "" && !noEncryption {
+			identities, err := readIdentities(identityKeys)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+
+			signers, err := readSigners(signerKeys)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+
+			// Check Password Requirement for extraction (when encryption is not disabled), key shares and identities may need none
+			if password == "" && !noEncryption && len(container) == 1 && identities == nil {
 				fmt.Println("Error: Password is required for decryption when --noencryption is not used.")
 				cmd.Usage()
 				os.Exit(1)
 			}
 
+			if depth != 0 {
+				if err := stego.ValidateDepth(depth); err != nil {
+					fmt.Println("Error:", err)
+					cmd.Usage()
+					os.Exit(1)
+				}
+			}
+
 			// If validation passed, print out the parameters and proceed with the extract logic
-			fmt.Printf("Extracting data from '%s' to '%s' (container: '%s', encryption: %v)\n",
-				inputFile, outputFile, container, !noEncryption)
+			if !list {
+				fmt.Printf("Extracting data from '%s' to '%s' (encryption: %v)\n",
+					strings.Join(container, "', '"), outputFile, !noEncryption)
+			}
+
+			if verbose && noEncryption {
+				fmt.Println("[DEBUG] Encryption disabled.")
+			}
+
+			// The key is derived inside Open, from the salt and cost stored in the GDP header
+			opts := stego.ExtractOptions{
+				Password:     password,
+				Depth:        depth,
+				LegacyLayout: legacyLayout,
+				NoPreserve:   noPreserve,
+				Identities:   identities,
+				Signers:      signers,
+				Logf:         debugLogger(verbose),
+			}
 
-			var key []byte
-			if !noEncryption {
-				key = utils.DeriveKey(password)
-				if verbose {
-					fmt.Println("[DEBUG] Encryption disabled.")
+			payload, err := stego.OpenSet(container, opts)
+			if err != nil {
+				fmt.Println("Error extracting:", err)
+				os.Exit(1)
+			}
+			if payload.Pieces > 1 {
+				fmt.Printf("Reassembled the payload from %d containers\n", payload.Pieces)
+			}
+			if payload.Shares > 0 {
+				fmt.Printf("Recovered the key from %d key shares\n", payload.Shares)
+			}
+			if payload.Signer != nil {
+				if signers != nil {
+					fmt.Println("Signature verified: signed by", payload.Signer)
+				} else {
+					fmt.Printf("Signed by %s, pass --signer-pubkey to check it is a key you trust\n", payload.Signer)
+				}
+			}
+			if len(payload.Missing) > 0 {
+				fmt.Printf("Sync blocks %s of %d were missing and have been rebuilt\n", stego.FormatBlocks(payload.Missing), payload.Blocks)
+			}
+			if payload.Corrected > 0 {
+				fmt.Printf("Error correction repaired %d damaged bytes\n", payload.Corrected)
+			}
+
+			if list {
+				if payload.Entries == nil {
+					fmt.Printf("Raw payload of %d bytes, no file names stored\n", len(payload.Raw))
 				}
-			} else if verbose {
-				fmt.Println("[DEBUG] Encryption enabled. Deriving key...")
+				for _, entry := range payload.Entries {
+					fmt.Println(stego.FormatFileEntry(entry))
+				}
+				return
 			}
 
-			err := utils.Extract(container, outputFile, key, !noEncryption, verbose)
+			path, err := stego.WritePayload(payload, outputFile, !noPreserve)
 			if err != nil {
 				fmt.Println("Error extracting:", err)
 				os.Exit(1)
 			}
 
+			// Print Success
+			fmt.Println("Success: Plaintext extracted and written to", path)
+		},
+	}
+
+	// Define the "capacity" command
+	var capacityCmd = &cobra.Command{
+		Use:   "capacity",
+		Short: "Show how much data a WAV file can hold",
+		Run: func(cmd *cobra.Command, args []string) {
+			container := singleContainer(cmd, containers)
+
+			// Input files are optional, they are only used for the fit estimate
+			inputFiles = append(inputFiles, args...)
+
+			// The header fields embed would write take room as well
+			checksum, err := stego.ParseChecksum(checksumName)
+			if err != nil {
+				fmt.Println("Error:", err)
+				cmd.Usage()
+				os.Exit(1)
+			}
+			padSize, err := stego.ParsePadding(padding)
+			if err != nil {
+				fmt.Println("Error:", err)
+				cmd.Usage()
+				os.Exit(1)
+			}
+			recipients, err := readRecipients(recipientKeys)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			cipherAlgorithm, err := stego.ParseCipher(cipherName)
+			if err != nil {
+				fmt.Println("Error:", err)
+				cmd.Usage()
+				os.Exit(1)
+			}
+			var signingKey *stego.SigningKey
+			if signKey != "" {
+				if signingKey, err = readSigningKey(signKey); err != nil {
+					fmt.Println("Error:", err)
+					os.Exit(1)
+				}
+			}
+			if fec != 0 {
+				if err := stego.ValidateFEC(fec); err != nil {
+					fmt.Println("Error:", err)
+					cmd.Usage()
+					os.Exit(1)
+				}
+			}
+			opts := stego.EmbedOptions{
+				Password:   password,
+				Cipher:     cipherAlgorithm,
+				Checksum:   checksum,
+				Recipients: recipients,
+				SignKey:    signingKey,
+				Padding:    padSize,
+				FEC:        fec,
+				Sync:       sync,
+				Stealth:    stealth,
+			}
+			report, err := stego.ContainerCapacity(container, inputFiles, threshold, opts)
+			if err != nil {
+				fmt.Println("Error reading capacity:", err)
+				os.Exit(1)
+			}
+
+			if jsonOutput {
+				printJSON(report)
+				return
+			}
+			printCapacity(report)
+		},
+	}
+
+	// Define the "verify" command
+	var verifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Check the data hidden in a WAV file against its stored checksum",
+		Run: func(cmd *cobra.Command, args []string) {
+			container, err := expandContainers(containers)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+			if len(container) == 0 {
+				fmt.Println("Error: Container WAV file is required.")
+				cmd.Usage()
+				os.Exit(1)
+			}
+
+			identities, err := readIdentities(identityKeys)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+
+			signers, err := readSigners(signerKeys)
+			if err != nil {
+				fmt.Println("Error:", err)
+				os.Exit(1)
+			}
+
+			// The payload is extracted in memory only, nothing is written
+			verification, err := stego.VerifySet(container, stego.ExtractOptions{
+				Password:     password,
+				Depth:        depth,
+				LegacyLayout: legacyLayout,
+				Identities:   identities,
+				Signers:      signers,
+				Logf:         debugLogger(verbose),
+			})
+			if err != nil {
+				fmt.Println("Error verifying:", err)
+				os.Exit(1)
+			}
+
+			// Unencrypted data without a digest may be damaged without anything noticing
+			ok := true
+			var message string
+			switch {
+			case verification.Checksum != "none":
+				message = fmt.Sprintf("OK: %d bytes match the stored %s checksum", verification.Size, verification.Checksum)
+			case verification.Encrypted:
+				message = fmt.Sprintf("OK: %d bytes authenticated by %s, no checksum stored", verification.Size, verification.Cipher)
+			default:
+				ok = false
+				message = "Error verifying: no checksum stored and the payload is not encrypted, its integrity cannot be checked"
+			}
+			if verification.Corrected > 0 {
+				message += fmt.Sprintf(" (after repairing %d damaged bytes)", verification.Corrected)
+			}
+			if ok && verification.Signer != "" {
+				message += ", signed by " + verification.Signer
+			}
+
+			if jsonOutput {
+				printJSON(verification)
+			} else {
+				fmt.Println(message)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+		},
+	}
+
+	// Define the "info" command
+	var infoCmd = &cobra.Command{
+		Use:   "info",
+		Short: "Show the GDP header hidden in a WAV file without decrypting it",
+		Run: func(cmd *cobra.Command, args []string) {
+			container := singleContainer(cmd, containers)
+
+			// The password only locates scattered data, nothing is decrypted
+			info, err := stego.Inspect(container, stego.ExtractOptions{
+				Password:     password,
+				Depth:        depth,
+				LegacyLayout: legacyLayout,
+				Logf:         debugLogger(verbose),
+			})
+			if err != nil {
+				fmt.Println("Error inspecting:", err)
+				os.Exit(1)
+			}
+
+			if jsonOutput {
+				printJSON(info)
+				return
+			}
+			printInfo(info)
+		},
+	}
+
+	// Define the "keygen" command
+	var keygenCmd = &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate an X25519 identity for --recipient and --identity, or with --sign an Ed25519 key for --sign-key",
+		Run: func(cmd *cobra.Command, args []string) {
+			var public, contents string
+			if signing {
+				key, err := stego.GenerateSigningKey()
+				if err != nil {
+					fmt.Println("Error generating key:", err)
+					os.Exit(1)
+				}
+				public = key.Public().String()
+				contents = fmt.Sprintf("# public key: %s\n%s\n", public, key)
+			} else {
+				identity, err := stego.GenerateIdentity()
+				if err != nil {
+					fmt.Println("Error generating key:", err)
+					os.Exit(1)
+				}
+				public = identity.Recipient().String()
+				contents = fmt.Sprintf("# public key: %s\n%s\n", public, identity)
+			}
+
+			// The private key goes to the output file when one is given, the public key is always shown
+			if outputFile == "" {
+				fmt.Print(contents)
+				return
+			}
+			if _, err := os.Stat(outputFile); err == nil {
+				fmt.Printf("Error: %s already exists, refusing to overwrite a key\n", outputFile)
+				os.Exit(1)
+			}
+			if err := os.WriteFile(outputFile, []byte(contents), 0600); err != nil {
+				fmt.Println("Error writing key:", err)
+				os.Exit(1)
+			}
+			fmt.Println("Public key:", public)
 		},
 	}
 
+	keygenCmd.Flags().BoolVarP(&signing, "sign", "", false, "Generate an Ed25519 signing key instead of an X25519 identity")
+
 	// Define the "extract" command
 	var guiCmd = &cobra.Command{
 		Use:   "gui",
@@ -150,8 +670,8 @@ func main() {
 		},
 	}
 
-	// Add the embed and extract commands to the root command
-	rootCmd.AddCommand(embedCmd, extractCmd, guiCmd)
+	// Add the subcommands to the root command
+	rootCmd.AddCommand(embedCmd, extractCmd, capacityCmd, infoCmd, verifyCmd, keygenCmd, guiCmd)
 
 	// Add bash completion command
 	var completionCmd = &cobra.Command{
@@ -174,3 +694,253 @@ func main() {
 	}
 	
 }
+
+// expandContainers replaces the directories among the given containers with
+// the WAV files they hold, in name order.
+func expandContainers(paths []string) ([]string, error) {
+	var containers []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() {
+			containers = append(containers, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.wav"))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no .wav files in %s", path)
+		}
+		sort.Strings(matches)
+		containers = append(containers, matches...)
+	}
+	return containers, nil
+}
+
+// singleContainer returns the one container a command works on, or exits.
+func singleContainer(cmd *cobra.Command, containers []string) string {
+	container, err := expandContainers(containers)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if len(container) != 1 {
+		fmt.Println("Error: Exactly one container WAV file is required.")
+		cmd.Usage()
+		os.Exit(1)
+	}
+	return container[0]
+}
+
+// readRecipients parses the public keys given with --recipient, each a key or a file of them.
+func readRecipients(sources []string) ([]*stego.Recipient, error) {
+	var recipients []*stego.Recipient
+	for _, source := range sources {
+		keys, err := stego.ReadKeys(source)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			recipient, err := stego.ParseRecipient(key)
+			if err != nil {
+				return nil, err
+			}
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients, nil
+}
+
+// readIdentities parses the private keys given with --identity, each a key or a file of them.
+func readIdentities(sources []string) ([]*stego.Identity, error) {
+	var identities []*stego.Identity
+	for _, source := range sources {
+		keys, err := stego.ReadKeys(source)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			identity, err := stego.ParseIdentity(key)
+			if err != nil {
+				return nil, err
+			}
+			identities = append(identities, identity)
+		}
+	}
+	return identities, nil
+}
+
+// readSigningKey parses the private key given with --sign-key, a key or a file holding one.
+func readSigningKey(source string) (*stego.SigningKey, error) {
+	keys, err := stego.ReadKeys(source)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != 1 {
+		return nil, fmt.Errorf("%s holds %d keys, a single signing key is needed", source, len(keys))
+	}
+	return stego.ParseSigningKey(keys[0])
+}
+
+// readSigners parses the public keys given with --signer-pubkey, each a key or a file of them.
+func readSigners(sources []string) ([]*stego.VerifyingKey, error) {
+	var signers []*stego.VerifyingKey
+	for _, source := range sources {
+		keys, err := stego.ReadKeys(source)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			signer, err := stego.ParseVerifyingKey(key)
+			if err != nil {
+				return nil, err
+			}
+			signers = append(signers, signer)
+		}
+	}
+	return signers, nil
+}
+
+// debugLogger returns a logger that prints progress messages when verbose is set.
+func debugLogger(verbose bool) func(string, ...any) {
+	if !verbose {
+		return nil
+	}
+	return func(format string, args ...any) {
+		fmt.Printf("[DEBUG] "+format+"\n", args...)
+	}
+}
+
+// printJSON prints a report as indented JSON.
+func printJSON(report any) {
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Println("Error encoding JSON:", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(out))
+}
+
+// printCapacity prints a capacity report as a table.
+func printCapacity(report *stego.CapacityReport) {
+	fmt.Printf("Container: %s (format %d, %d-bit, %d channels, %d Hz, %d samples)\n",
+		report.Container, report.AudioFormat, report.BitDepth, report.Channels, report.SampleRate, report.Samples)
+	if report.InputCompressedBytes > 0 {
+		fmt.Printf("Input: %d bytes, about %d bytes after XZ compression\n", report.InputBytes, report.InputCompressedBytes)
+	}
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	header := "Depth\tEncryption\tRaw capacity\tUsable payload"
+	if report.InputCompressedBytes > 0 {
+		header += "\tInput fits"
+	}
+	fmt.Fprintln(w, header)
+	for _, capacity := range report.Capacities {
+		encryption := "none"
+		if capacity.Encrypted {
+			encryption = report.Cipher
+		}
+		line := fmt.Sprintf("%d\t%s\t%d bits\t%d bytes", capacity.Depth, encryption, capacity.RawBits, capacity.UsableBytes)
+		if capacity.InputFits != nil {
+			fits := "no"
+			if *capacity.InputFits {
+				fits = "yes"
+			}
+			line += fmt.Sprintf("\t%s (%.0f%%)", fits, *capacity.InputPercent)
+		}
+		fmt.Fprintln(w, line)
+	}
+	w.Flush()
+}
+
+// printInfo prints what was found in a container.
+func printInfo(info *stego.ContainerInfo) {
+	fmt.Printf("Container: %s (format %d, %d-bit, %d channels, %d Hz, %d samples)\n",
+		info.Container, info.AudioFormat, info.BitDepth, info.Channels, info.SampleRate, info.Samples)
+	fmt.Println("Chunks:", strings.Join(info.Chunks, ", "))
+	if !info.Present {
+		fmt.Println("GDP payload: not found,", info.Reason)
+		return
+	}
+
+	layout := "sequential"
+	if info.Scattered {
+		layout = "scattered"
+	}
+	if info.Legacy {
+		layout += ", no layout byte"
+	}
+	if info.Stealth {
+		layout = "stealth, masked and scattered from the first sample"
+	}
+	if info.Blocks != 0 {
+		layout += fmt.Sprintf(", %d sync blocks from sample %d", info.Blocks, info.Offset)
+	}
+	if info.FEC != 0 {
+		layout += fmt.Sprintf(", error correction with %d parity bytes per block", info.FEC)
+	}
+	header := info.Header
+	if piece := info.Piece; piece != nil {
+		fmt.Printf("Split set %s: piece %d of %d, %d of %d bytes, %d LSB per sample, %s\n",
+			piece.SetID, piece.Index+1, piece.Count, piece.Length, piece.Total, info.Depth, layout)
+	}
+	if header != nil {
+		fmt.Printf("GDP payload: version %d, %d LSB per sample, %s\n", header.Version, info.Depth, layout)
+	}
+	if len(info.Missing) > 0 {
+		fmt.Printf("Missing sync blocks: %s\n", stego.FormatBlocks(info.Missing))
+	}
+	if info.Corrected > 0 {
+		fmt.Printf("Error correction repaired %d damaged bytes\n", info.Corrected)
+	}
+	if header == nil {
+		fmt.Println("GDP header: in the first piece of the set")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if header.Encrypted {
+		kdf := header.KDF
+		fmt.Fprintf(w, "  Encryption:\t%s, %d-byte nonce\n", header.Cipher, header.NonceSize)
+		if kdf.Algorithm != "none" {
+			fmt.Fprintf(w, "  KDF:\t%s (time %d, memory %d KiB, threads %d, %d-byte salt)\n",
+				kdf.Algorithm, kdf.Time, kdf.MemoryKiB, kdf.Threads, kdf.SaltSize)
+		}
+		if header.Recipients > 0 {
+			fmt.Fprintf(w, "  Recipients:\t%d X25519 keys\n", header.Recipients)
+		}
+		if share := header.Share; share != nil {
+			fmt.Fprintf(w, "  Key share:\t%d of %d of set %s, any %d recover the key\n",
+				share.Index, share.Count, share.SetID, share.Threshold)
+		}
+	} else {
+		fmt.Fprintln(w, "  Encryption:\tnone")
+	}
+	fmt.Fprintf(w, "  Compression:\t%s\n", header.Compression)
+	fmt.Fprintf(w, "  Content:\t%s\n", header.Content)
+	if header.Filename != "" {
+		fmt.Fprintf(w, "  File name:\t%s\n", header.Filename)
+	}
+	if header.MIMEType != "" {
+		fmt.Fprintf(w, "  MIME type:\t%s\n", header.MIMEType)
+	}
+	if header.Checksum != "" {
+		fmt.Fprintf(w, "  Checksum:\t%s\n", header.Checksum)
+	}
+	if header.Signer != "" {
+		fmt.Fprintf(w, "  Signed by:\t%s (not checked)\n", header.Signer)
+	} else if header.Signed {
+		fmt.Fprintln(w, "  Signed:\tyes, the signer is encrypted with the payload")
+	}
+	if header.Padded {
+		fmt.Fprintln(w, "  Padding:\tyes, the ciphertext size does not give away the payload's")
+	}
+	for _, ext := range header.Extensions {
+		fmt.Fprintf(w, "  Extension:\t0x%02x\n", ext)
+	}
+	fmt.Fprintf(w, "  Header size:\t%d bytes\n", header.HeaderSize)
+	fmt.Fprintf(w, "  Ciphertext size:\t%d bytes\n", header.CiphertextSize)
+	w.Flush()
+}
@@ -1,13 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 	"crypto/sha256"
 	"golang.org/x/crypto/pbkdf2"
 
-	"github.com/sarange/godeep/utils"
+	"github.com/sarange/godeep/pkg/stego"
 )
 
 // Test files
@@ -18,18 +29,27 @@ const (
 	testExtractedFile      = "tests/extracted_secret.txt"
 	testOutputWAVNoPass    = "tests/output_no_pass.wav"
 	testExtractedFileNoPass = "tests/extracted_no_pass.txt"
+	testOutputWAVDepth     = "tests/output_depth.wav"
+	testExtractedFileDepth = "tests/extracted_depth.txt"
+	testOutputWAVLegacy    = "tests/output_legacy.wav"
+	testExtractedFileLegacy = "tests/extracted_legacy.txt"
 	testPassword           = "testpassword"
 )
 
-// Generate encryption key
+// Generate the fixed-salt encryption key used by version 1 GDP files
 func generateKey() []byte {
 	return pbkdf2.Key([]byte(testPassword), []byte("GoDeepSalt"), 100000, 32, sha256.New)
 }
 
 // **Test 1: Embed using `utils/` (With Password)**
 func TestEmbedWithPassword(t *testing.T) {
-	key := generateKey()
-	err := utils.Embed(testSecretFile, testOutputWAV, testContainerWAV, key, true, true)
+	err := stego.Embed([]string{testSecretFile}, testOutputWAV, testContainerWAV, stego.EmbedOptions{
+		Password:   testPassword,
+		Encryption: true,
+		KDF:        stego.DefaultKDFParams(),
+		Depth:      1,
+		Logf:       t.Logf,
+	})
 	if err != nil {
 		t.Fatalf("Embedding failed: %v", err)
 	}
@@ -41,8 +61,7 @@ func TestEmbedWithPassword(t *testing.T) {
 
 // **Test 2: Extract using `utils/` (With Password)**
 func TestExtractWithPassword(t *testing.T) {
-	key := generateKey()
-	err := utils.Extract(testOutputWAV, testExtractedFile, key, true, true)
+	_, err := stego.Extract(testOutputWAV, testExtractedFile, stego.ExtractOptions{Password: testPassword, Logf: t.Logf})
 	if err != nil {
 		t.Fatalf("Extraction failed: %v", err)
 	}
@@ -68,7 +87,7 @@ func TestExtractWithPassword(t *testing.T) {
 
 // **Test 3: Embed using `utils/` (No Password)**
 func TestEmbedNoPassword(t *testing.T) {
-	err := utils.Embed(testSecretFile, testOutputWAVNoPass, testContainerWAV, nil, false, true)
+	err := stego.Embed([]string{testSecretFile}, testOutputWAVNoPass, testContainerWAV, stego.EmbedOptions{Depth: 1, Logf: t.Logf})
 	if err != nil {
 		t.Fatalf("Embedding (no password) failed: %v", err)
 	}
@@ -80,7 +99,7 @@ func TestEmbedNoPassword(t *testing.T) {
 
 // **Test 4: Extract using `utils/` (No Password)**
 func TestExtractNoPassword(t *testing.T) {
-	err := utils.Extract(testOutputWAVNoPass, testExtractedFileNoPass, nil, false, true)
+	_, err := stego.Extract(testOutputWAVNoPass, testExtractedFileNoPass, stego.ExtractOptions{Logf: t.Logf})
 	if err != nil {
 		t.Fatalf("Extraction (no password) failed: %v", err)
 	}
@@ -183,3 +202,1762 @@ func TestCLI_ExtractNoPassword(t *testing.T) {
 		t.Fatalf("Extracted data (no password) from CLI does not match original secret file")
 	}
 }
+
+// **Test 9: CLI - Embed and Extract with depth 4**
+func TestCLI_EmbedExtractDepth(t *testing.T) {
+	cmd := exec.Command("./godeep", "embed", "-i", testSecretFile, "-o", testOutputWAVDepth, "-c", testContainerWAV, "-p", testPassword, "--depth", "4")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("CLI Embed (depth 4) failed: %v", err)
+	}
+
+	// The depth is read back from the container
+	cmd = exec.Command("./godeep", "extract", "-c", testOutputWAVDepth, "-o", testExtractedFileDepth, "-p", testPassword)
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("CLI Extract (depth 4) failed: %v", err)
+	}
+
+	originalData, err := os.ReadFile(testSecretFile)
+	if err != nil {
+		t.Fatalf("Failed to read original secret file: %v", err)
+	}
+
+	extractedData, err := os.ReadFile(testExtractedFileDepth)
+	if err != nil {
+		t.Fatalf("Failed to read extracted file: %v", err)
+	}
+
+	if string(originalData) != string(extractedData) {
+		t.Fatalf("Extracted data (depth 4) does not match original secret file")
+	}
+}
+
+// **Test 10: Extract using `utils/` (Legacy byte layout and version 1 GDP file)**
+func TestExtractLegacyLayout(t *testing.T) {
+	originalData, err := os.ReadFile(testSecretFile)
+	if err != nil {
+		t.Fatalf("Failed to read original secret file: %v", err)
+	}
+
+	// Build a container the way older versions did: a version 1 GDP file
+	// encrypted with the fixed-salt key, spread over every PCM byte with no
+	// layout byte in front
+	ciphertext, nonce, err := stego.CompressAndEncrypt(originalData, generateKey())
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+	var gdpFile bytes.Buffer
+	gdpFile.WriteString("GDP")
+	gdpFile.WriteByte(1)
+	gdpFile.WriteByte(byte(len(nonce)))
+	gdpFile.Write(nonce)
+	binary.Write(&gdpFile, binary.LittleEndian, uint64(len(ciphertext)))
+	gdpFile.Write(ciphertext)
+
+	container := readTestWAV(t, testContainerWAV)
+	for i := 0; i < gdpFile.Len()*8; i++ {
+		container.pcm[i] = container.pcm[i]&0xFE | gdpFile.Bytes()[i/8]>>(i%8)&0x01
+	}
+	if err := os.WriteFile(testOutputWAVLegacy, container.file, 0644); err != nil {
+		t.Fatalf("Writing container failed: %v", err)
+	}
+
+	_, err = stego.Extract(testOutputWAVLegacy, testExtractedFileLegacy, stego.ExtractOptions{
+		Password:     testPassword,
+		LegacyLayout: true,
+		Logf:         t.Logf,
+	})
+	if err != nil {
+		t.Fatalf("Extraction (legacy layout) failed: %v", err)
+	}
+
+	extractedData, err := os.ReadFile(testExtractedFileLegacy)
+	if err != nil {
+		t.Fatalf("Failed to read extracted file: %v", err)
+	}
+
+	if string(originalData) != string(extractedData) {
+		t.Fatalf("Extracted data (legacy layout) does not match original secret file")
+	}
+}
+
+// writeTestWAV writes a short noise WAV file with the given format code, bit depth and fmt chunk size.
+func writeTestWAV(t *testing.T, path string, audioFormat uint16, bitDepth uint16, fmtSize int) []byte {
+	const sampleRate, numChans, numFrames = 8000, 2, 40000
+
+	blockAlign := numChans * bitDepth / 8
+	fmtChunk := make([]byte, fmtSize)
+	binary.LittleEndian.PutUint16(fmtChunk[0:], audioFormat)
+	binary.LittleEndian.PutUint16(fmtChunk[2:], numChans)
+	binary.LittleEndian.PutUint32(fmtChunk[4:], sampleRate)
+	binary.LittleEndian.PutUint32(fmtChunk[8:], sampleRate*uint32(blockAlign))
+	binary.LittleEndian.PutUint16(fmtChunk[12:], blockAlign)
+	binary.LittleEndian.PutUint16(fmtChunk[14:], bitDepth)
+	if audioFormat == 0xFFFE {
+		binary.LittleEndian.PutUint16(fmtChunk[16:], 22)
+		binary.LittleEndian.PutUint16(fmtChunk[18:], bitDepth)
+		binary.LittleEndian.PutUint16(fmtChunk[24:], 1) // KSDATAFORMAT_SUBTYPE_PCM
+	}
+
+	samples := make([]byte, numFrames*int(blockAlign))
+	rand.New(rand.NewSource(1)).Read(samples)
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(4+8+len(fmtChunk)+8+len(samples)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(fmtChunk)))
+	buf.Write(fmtChunk)
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(samples)))
+	buf.Write(samples)
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test WAV: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// testWAV is a WAV file split into its RIFF chunks, with the sample data as a slice of the file.
+type testWAV struct {
+	file       []byte
+	chunks     []testChunk
+	pcm        []byte
+	sampleSize int
+}
+
+// testChunk is a RIFF chunk of a test WAV file, Offset being the position of its body.
+type testChunk struct {
+	ID     string
+	Offset int
+	Size   int
+}
+
+// readTestWAV reads a WAV file and walks its RIFF chunks, failing the test on a malformed file.
+func readTestWAV(t *testing.T, path string) *testWAV {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read WAV: %v", err)
+	}
+	if len(file) < 12 || string(file[0:4]) != "RIFF" || string(file[8:12]) != "WAVE" {
+		t.Fatalf("%s is not a RIFF WAVE file", path)
+	}
+	if size := binary.LittleEndian.Uint32(file[4:8]); int(size) != len(file)-8 {
+		t.Fatalf("%s has a RIFF size of %d for %d bytes", path, size, len(file)-8)
+	}
+
+	wav := &testWAV{file: file}
+	for offset := 12; offset+8 <= len(file); {
+		chunk := testChunk{ID: string(file[offset : offset+4]), Offset: offset + 8, Size: int(binary.LittleEndian.Uint32(file[offset+4 : offset+8]))}
+		if chunk.Offset+chunk.Size > len(file) {
+			t.Fatalf("%s has a truncated %q chunk", path, chunk.ID)
+		}
+		body := file[chunk.Offset : chunk.Offset+chunk.Size]
+		switch chunk.ID {
+		case "fmt ":
+			wav.sampleSize = int(binary.LittleEndian.Uint16(body[12:14]) / binary.LittleEndian.Uint16(body[2:4]))
+		case "data":
+			wav.pcm = body
+		}
+		wav.chunks = append(wav.chunks, chunk)
+		offset = chunk.Offset + chunk.Size + chunk.Size%2
+	}
+	if wav.pcm == nil || wav.sampleSize == 0 {
+		t.Fatalf("%s has no fmt or data chunk", path)
+	}
+	return wav
+}
+
+// **Test 11: Embed and Extract using `utils/` (8/16/24/32-bit PCM and float carriers)**
+func TestEmbedExtractFormats(t *testing.T) {
+	formats := []struct {
+		name        string
+		audioFormat uint16
+		bitDepth    uint16
+		fmtSize     int
+	}{
+		{"pcm8", 1, 8, 16},
+		{"pcm16", 1, 16, 16},
+		{"pcm24", 1, 24, 16},
+		{"pcm32", 1, 32, 16},
+		{"float32", 3, 32, 18},
+		{"float64", 3, 64, 18},
+		{"extensible24", 0xFFFE, 24, 40},
+	}
+
+	originalData, err := os.ReadFile(testSecretFile)
+	if err != nil {
+		t.Fatalf("Failed to read original secret file: %v", err)
+	}
+
+	for _, format := range formats {
+		t.Run(format.name, func(t *testing.T) {
+			containerPath := "tests/container_" + format.name + ".wav"
+			outputPath := "tests/output_" + format.name + ".wav"
+			extractedPath := "tests/extracted_" + format.name + ".txt"
+			containerFile := writeTestWAV(t, containerPath, format.audioFormat, format.bitDepth, format.fmtSize)
+
+			if err := stego.Embed([]string{testSecretFile}, outputPath, containerPath, stego.EmbedOptions{Depth: 2, Logf: t.Logf}); err != nil {
+				t.Fatalf("Embedding failed: %v", err)
+			}
+
+			outputFile, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatalf("Failed to read output WAV: %v", err)
+			}
+			if len(outputFile) != len(containerFile) {
+				t.Fatalf("Output WAV size %d differs from container size %d", len(outputFile), len(containerFile))
+			}
+
+			// Only the two lowest bits of each sample may change
+			headerSize := 20 + format.fmtSize + 8
+			if !bytes.Equal(outputFile[:headerSize], containerFile[:headerSize]) {
+				t.Fatalf("Output WAV header differs from container header")
+			}
+			sampleSize := int(format.bitDepth / 8)
+			for i := headerSize; i < len(outputFile); i++ {
+				mask := byte(0xFF)
+				if (i-headerSize)%sampleSize == 0 {
+					mask = 0xFC
+				}
+				if outputFile[i]&mask != containerFile[i]&mask {
+					t.Fatalf("Sample byte %d changed beyond the embedding depth", i-headerSize)
+				}
+			}
+
+			if _, err := stego.Extract(outputPath, extractedPath, stego.ExtractOptions{Logf: t.Logf}); err != nil {
+				t.Fatalf("Extraction failed: %v", err)
+			}
+
+			extractedData, err := os.ReadFile(extractedPath)
+			if err != nil {
+				t.Fatalf("Failed to read extracted file: %v", err)
+			}
+			if !bytes.Equal(originalData, extractedData) {
+				t.Fatalf("Extracted data does not match original secret file")
+			}
+		})
+	}
+}
+
+// **Test 12: Embed using `utils/` (Non-audio RIFF chunks are preserved)**
+func TestEmbedPreservesChunks(t *testing.T) {
+	const containerPath = "tests/container_chunks.wav"
+	const outputPath = "tests/output_chunks.wav"
+
+	// Surround the fmt and data chunks with metadata chunks, including an odd-sized one
+	plain := writeTestWAV(t, "tests/container_plain.wav", 1, 24, 16)
+	chunk := func(id string, body string) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(id)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(body)))
+		buf.WriteString(body)
+		if len(body)%2 == 1 {
+			buf.WriteByte(0)
+		}
+		return buf.Bytes()
+	}
+	var body bytes.Buffer
+	body.WriteString("WAVE")
+	body.Write(chunk("bext", "GoDeep broadcast extension"))
+	body.Write(plain[12:])
+	body.Write(chunk("LIST", "INFOINAM\x06\x00\x00\x00Title\x00"))
+	body.Write(chunk("iXML", "<BWFXML/>"))
+
+	var containerFile bytes.Buffer
+	containerFile.WriteString("RIFF")
+	binary.Write(&containerFile, binary.LittleEndian, uint32(body.Len()))
+	containerFile.Write(body.Bytes())
+	if err := os.WriteFile(containerPath, containerFile.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test WAV: %v", err)
+	}
+
+	if err := stego.Embed([]string{testSecretFile}, outputPath, containerPath, stego.EmbedOptions{Depth: 1, Logf: t.Logf}); err != nil {
+		t.Fatalf("Embedding failed: %v", err)
+	}
+
+	outputFile, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output WAV: %v", err)
+	}
+
+	output := readTestWAV(t, outputPath)
+	var ids []string
+	for _, c := range output.chunks {
+		ids = append(ids, c.ID)
+	}
+	if got := strings.Join(ids, ","); got != "bext,fmt ,data,LIST,iXML" {
+		t.Fatalf("Unexpected chunk order: %s", got)
+	}
+
+	// Everything outside the sample data must be identical
+	for _, c := range output.chunks {
+		if c.ID == "data" {
+			if !bytes.Equal(outputFile[:c.Offset], containerFile.Bytes()[:c.Offset]) ||
+				!bytes.Equal(outputFile[c.Offset+c.Size:], containerFile.Bytes()[c.Offset+c.Size:]) {
+				t.Fatalf("Non-audio bytes changed while embedding")
+			}
+		}
+	}
+}
+
+// **Test 13: CLI - Embed and Extract with scattered placement**
+func TestCLI_EmbedExtractScatter(t *testing.T) {
+	const outputPath = "tests/output_scatter.wav"
+	const extractedPath = "tests/extracted_scatter.txt"
+
+	cmd := exec.Command("./godeep", "embed", "-i", testSecretFile, "-o", outputPath, "-c", testContainerWAV, "-p", testPassword, "--scatter")
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("CLI Embed (scatter) failed: %v", err)
+	}
+
+	// The GDP magic must not sit in the first samples
+	output := readTestWAV(t, outputPath)
+	magic := make([]byte, 3)
+	for i := 0; i < len(magic)*8; i++ {
+		magic[i/8] |= (output.pcm[(24+i)*output.sampleSize] & 0x01) << (i % 8)
+	}
+	if string(magic) == "GDP" {
+		t.Fatalf("Scattered payload starts in sample order")
+	}
+
+	cmd = exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "-p", testPassword)
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("CLI Extract (scatter) failed: %v", err)
+	}
+
+	originalData, err := os.ReadFile(testSecretFile)
+	if err != nil {
+		t.Fatalf("Failed to read original secret file: %v", err)
+	}
+
+	extractedData, err := os.ReadFile(extractedPath)
+	if err != nil {
+		t.Fatalf("Failed to read extracted file: %v", err)
+	}
+
+	if string(originalData) != string(extractedData) {
+		t.Fatalf("Extracted data (scatter) does not match original secret file")
+	}
+}
+
+// **Test 14: Embed using `utils/` (Random salt and KDF parameters in the GDP header)**
+func TestEmbedStoresKDFParams(t *testing.T) {
+	var salts [][]byte
+	for i := 0; i < 2; i++ {
+		outputPath := "tests/output_kdf_" + string(rune('a'+i)) + ".wav"
+		err := stego.Embed([]string{testSecretFile}, outputPath, testContainerWAV, stego.EmbedOptions{
+			Password:   testPassword,
+			Encryption: true,
+			KDF:        stego.DefaultKDFParams(),
+			Depth:      1,
+		})
+		if err != nil {
+			t.Fatalf("Embedding failed: %v", err)
+		}
+
+		payload, err := stego.Open(outputPath, stego.ExtractOptions{Password: testPassword})
+		if err != nil {
+			t.Fatalf("Opening the payload failed: %v", err)
+		}
+		kdf := payload.Header.KDF
+
+		if kdf.Algorithm != stego.KDFPBKDF2 || kdf.Time != stego.DefaultPBKDF2Iterations || len(kdf.Salt) != stego.SaltSize {
+			t.Fatalf("Unexpected KDF parameters in header: %+v", kdf)
+		}
+		salts = append(salts, kdf.Salt)
+	}
+
+	if bytes.Equal(salts[0], salts[1]) {
+		t.Fatalf("Two containers share the same salt")
+	}
+}
+
+// **Test 15: CLI - Embed and Extract with Argon2id and scrypt**
+func TestCLI_EmbedExtractKDF(t *testing.T) {
+	originalData, err := os.ReadFile(testSecretFile)
+	if err != nil {
+		t.Fatalf("Failed to read original secret file: %v", err)
+	}
+
+	for _, kdf := range []string{"argon2id", "scrypt"} {
+		t.Run(kdf, func(t *testing.T) {
+			outputPath := "tests/output_" + kdf + ".wav"
+			extractedPath := "tests/extracted_" + kdf + ".txt"
+
+			cmd := exec.Command("./godeep", "embed", "-i", testSecretFile, "-o", outputPath, "-c", testContainerWAV, "-p", testPassword,
+				"--kdf", kdf, "--kdf-memory", "16MiB", "--kdf-threads", "2")
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("CLI Embed (%s) failed: %v", kdf, err)
+			}
+
+			// Extract needs only the password, the KDF is read from the header
+			cmd = exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "-p", testPassword)
+			if err := cmd.Run(); err != nil {
+				t.Fatalf("CLI Extract (%s) failed: %v", kdf, err)
+			}
+
+			extractedData, err := os.ReadFile(extractedPath)
+			if err != nil {
+				t.Fatalf("Failed to read extracted file: %v", err)
+			}
+			if string(originalData) != string(extractedData) {
+				t.Fatalf("Extracted data (%s) does not match original secret file", kdf)
+			}
+
+			// A wrong password must not open it
+			cmd = exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "-p", "wrong"+testPassword)
+			if err := cmd.Run(); err == nil {
+				t.Fatalf("CLI Extract (%s) succeeded with a wrong password", kdf)
+			}
+		})
+	}
+
+	// A crafted header cannot ask for more than 1 GiB, or for a time cost scrypt does not have
+	salt := []byte("salt")
+	for _, params := range []stego.KDFParams{
+		{Algorithm: stego.KDFArgon2id, Time: 1, Memory: 2 * 1024 * 1024, Threads: 1, Salt: salt},
+		{Algorithm: stego.KDFScrypt, Memory: 1 << 21, Threads: 1, Salt: salt},
+		{Algorithm: stego.KDFScrypt, Time: 1, Memory: 1024, Threads: 1, Salt: salt},
+	} {
+		if _, err := stego.DeriveKeyWithParams(testPassword, params); err == nil {
+			t.Fatalf("DeriveKeyWithParams accepted %+v", params)
+		}
+	}
+	cmd := exec.Command("./godeep", "embed", "-i", testSecretFile, "-o", "tests/output_scrypt.wav", "-c", testContainerWAV, "-p", testPassword,
+		"--kdf", "scrypt", "--kdf-time", "5")
+	if err := cmd.Run(); err == nil {
+		t.Fatal("CLI Embed accepted a time cost for scrypt")
+	}
+}
+
+// **Test 16: GDP file extension fields**
+func TestGDPExtensions(t *testing.T) {
+	gdpFile, err := stego.MakeGDPFile(stego.GDPFile{
+		Encryption:  true,
+		KDF:         stego.KDFParams{Algorithm: stego.KDFArgon2id, Time: 3, Memory: 1024, Threads: 1, Salt: []byte("salt")},
+		Compression: stego.CompressionXZ,
+		Nonce:       []byte("nonce"),
+		Extensions: []stego.GDPExtension{
+			{Type: stego.ExtFilename, Value: []byte("secret.md")},
+			{Type: 0x7F, Value: []byte("from a newer version")},
+		},
+		Ciphertext: []byte("ciphertext"),
+	})
+	if err != nil {
+		t.Fatalf("Creating GDP file failed: %v", err)
+	}
+
+	gdp, err := stego.ParseGDPFile(gdpFile, false)
+	if err != nil {
+		t.Fatalf("Parsing GDP file failed: %v", err)
+	}
+	if gdp.Version != stego.GDPVersion || gdp.KDF.Algorithm != stego.KDFArgon2id || string(gdp.KDF.Salt) != "salt" ||
+		string(gdp.Nonce) != "nonce" || string(gdp.Ciphertext) != "ciphertext" {
+		t.Fatalf("GDP fields did not round-trip: %+v", gdp)
+	}
+	if name, ok := gdp.Extension(stego.ExtFilename); !ok || string(name) != "secret.md" {
+		t.Fatalf("Filename extension did not round-trip")
+	}
+	if _, ok := gdp.Extension(0x7F); !ok {
+		t.Fatalf("Unknown extension was not kept")
+	}
+
+	// Unknown critical extensions must be rejected
+	critical, err := stego.MakeGDPFile(stego.GDPFile{Extensions: []stego.GDPExtension{{Type: 0xFF}}})
+	if err != nil {
+		t.Fatalf("Creating GDP file failed: %v", err)
+	}
+	if _, err := stego.ParseGDPFile(critical, false); err == nil {
+		t.Fatalf("GDP file with an unknown critical extension was accepted")
+	}
+
+	// Version 2 headers are still read
+	var v2 bytes.Buffer
+	v2.WriteString("GDP")
+	v2.Write([]byte{2, 1, stego.KDFPBKDF2})
+	binary.Write(&v2, binary.LittleEndian, uint32(1000))
+	binary.Write(&v2, binary.LittleEndian, uint32(0))
+	v2.Write([]byte{0, 4})
+	v2.WriteString("salt")
+	v2.Write([]byte{5})
+	v2.WriteString("nonce")
+	binary.Write(&v2, binary.LittleEndian, uint64(10))
+	v2.WriteString("ciphertext")
+
+	gdp, err = stego.ParseGDPFile(v2.Bytes(), false)
+	if err != nil {
+		t.Fatalf("Parsing version 2 GDP file failed: %v", err)
+	}
+	if gdp.Version != 2 || gdp.KDF.Time != 1000 || string(gdp.KDF.Salt) != "salt" || string(gdp.Ciphertext) != "ciphertext" {
+		t.Fatalf("Version 2 GDP fields were not read: %+v", gdp)
+	}
+}
+
+// **Test 17: CLI - Extract into a directory restores the file name, mode and mtime**
+func TestCLI_ExtractPreservesMetadata(t *testing.T) {
+	const outputPath = "tests/output_preserve.wav"
+	dir := t.TempDir()
+
+	secretPath := filepath.Join(dir, "notes.md")
+	secret := []byte("metadata travels with the payload")
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.WriteFile(secretPath, secret, 0600); err != nil {
+		t.Fatalf("Writing secret file failed: %v", err)
+	}
+	if err := os.Chtimes(secretPath, modTime, modTime); err != nil {
+		t.Fatalf("Setting secret file mtime failed: %v", err)
+	}
+
+	cmd := exec.Command("./godeep", "embed", "-i", secretPath, "-o", outputPath, "-c", testContainerWAV, "-p", testPassword)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+
+	// Extract into a directory, the file gets its original name back
+	extractDir := filepath.Join(dir, "extracted")
+	if err := os.Mkdir(extractDir, 0755); err != nil {
+		t.Fatalf("Creating extract directory failed: %v", err)
+	}
+	cmd = exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractDir, "-p", testPassword)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Extract failed: %v\nOutput: %s", err, output)
+	}
+
+	extractedPath := filepath.Join(extractDir, "notes.md")
+	extracted, err := os.ReadFile(extractedPath)
+	if err != nil {
+		t.Fatalf("Reading extracted file failed: %v", err)
+	}
+	if !bytes.Equal(extracted, secret) {
+		t.Fatalf("Extracted content does not match original")
+	}
+	info, err := os.Stat(extractedPath)
+	if err != nil {
+		t.Fatalf("Stat on extracted file failed: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Mode not restored: got %v", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Fatalf("Modification time not restored: got %v, want %v", info.ModTime(), modTime)
+	}
+
+	// --no-preserve leaves the mode and mtime of a fresh file
+	noPreservePath := filepath.Join(dir, "no_preserve.md")
+	cmd = exec.Command("./godeep", "extract", "-c", outputPath, "-o", noPreservePath, "-p", testPassword, "--no-preserve")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Extract with --no-preserve failed: %v\nOutput: %s", err, output)
+	}
+	info, err = os.Stat(noPreservePath)
+	if err != nil {
+		t.Fatalf("Stat on extracted file failed: %v", err)
+	}
+	if info.ModTime().Equal(modTime) {
+		t.Fatalf("Modification time was restored despite --no-preserve")
+	}
+}
+
+// **Test 18: CLI - Embed, list and extract several files and a directory**
+func TestCLI_EmbedExtractTree(t *testing.T) {
+	const outputPath = "tests/output_tree.wav"
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"bundle/key.pem":         "-----BEGIN KEY-----",
+		"bundle/conf/app.yaml":   "debug: false",
+		"bundle/conf/empty.conf": "",
+		"README":                 "read me first",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, "src", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Creating test tree failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Creating test tree failed: %v", err)
+		}
+	}
+
+	cmd := exec.Command("./godeep", "embed", "-c", testContainerWAV, "-o", outputPath, "-p", testPassword,
+		"-i", filepath.Join(dir, "src", "bundle"), filepath.Join(dir, "src", "README"))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+
+	// --list shows the relative paths without writing anything
+	cmd = exec.Command("./godeep", "extract", "-c", outputPath, "-p", testPassword, "--list")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI Extract --list failed: %v\nOutput: %s", err, output)
+	}
+	for name := range files {
+		if !strings.Contains(string(output), name) {
+			t.Fatalf("Listing is missing %s:\n%s", name, output)
+		}
+	}
+
+	// Extracting recreates the tree in a new directory
+	extractDir := filepath.Join(dir, "out")
+	cmd = exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractDir, "-p", testPassword)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Extract failed: %v\nOutput: %s", err, output)
+	}
+	for name, content := range files {
+		extracted, err := os.ReadFile(filepath.Join(extractDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("Reading extracted %s failed: %v", name, err)
+		}
+		if string(extracted) != content {
+			t.Fatalf("Extracted %s does not match original", name)
+		}
+	}
+}
+
+// **Test 19: Library errors can be matched with errors.Is**
+func TestLibrarySentinelErrors(t *testing.T) {
+	const containerPath = "tests/container_errors.wav"
+	const outputPath = "tests/output_errors.wav"
+	dir := t.TempDir()
+	writeTestWAV(t, containerPath, 1, 16, 16)
+
+	// A payload larger than the container
+	bigFile := filepath.Join(dir, "big.bin")
+	big := make([]byte, 64*1024)
+	rand.New(rand.NewSource(2)).Read(big)
+	if err := os.WriteFile(bigFile, big, 0644); err != nil {
+		t.Fatalf("Writing large file failed: %v", err)
+	}
+	err := stego.Embed([]string{bigFile}, outputPath, containerPath, stego.EmbedOptions{Depth: 1})
+	if !errors.Is(err, stego.ErrCapacity) {
+		t.Fatalf("Oversized payload: got %v, want ErrCapacity", err)
+	}
+
+	// A container with nothing hidden in it
+	_, err = stego.Extract(containerPath, filepath.Join(dir, "out"), stego.ExtractOptions{})
+	if !errors.Is(err, stego.ErrBadMagic) {
+		t.Fatalf("Empty container: got %v, want ErrBadMagic", err)
+	}
+
+	// A wrong password
+	err = stego.Embed([]string{testSecretFile}, outputPath, containerPath, stego.EmbedOptions{
+		Password:   testPassword,
+		Encryption: true,
+		KDF:        stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000},
+	})
+	if err != nil {
+		t.Fatalf("Embedding failed: %v", err)
+	}
+	_, err = stego.Extract(outputPath, filepath.Join(dir, "out"), stego.ExtractOptions{Password: "wrong"})
+	if !errors.Is(err, stego.ErrAuthFailed) {
+		t.Fatalf("Wrong password: got %v, want ErrAuthFailed", err)
+	}
+
+	// A carrier that is not a WAV file
+	_, err = stego.Extract(testSecretFile, filepath.Join(dir, "out"), stego.ExtractOptions{})
+	if !errors.Is(err, stego.ErrUnsupportedFormat) {
+		t.Fatalf("Non-WAV carrier: got %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+// **Test 20: Streaming embed and extract through readers and pipes**
+func TestEmbedExtractStream(t *testing.T) {
+	carrier, err := os.ReadFile(testContainerWAV)
+	if err != nil {
+		t.Fatalf("Reading container failed: %v", err)
+	}
+	payload := []byte("streamed through a pipe")
+
+	for _, scatter := range []bool{false, true} {
+		output, err := os.Create(filepath.Join(t.TempDir(), "output.wav"))
+		if err != nil {
+			t.Fatalf("Creating output failed: %v", err)
+		}
+		defer output.Close()
+
+		opts := stego.EmbedOptions{Password: testPassword, Encryption: true, Scatter: scatter,
+			KDF: stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000}}
+		if err := stego.EmbedStream(output, bytes.NewReader(carrier), bytes.NewReader(payload), opts); err != nil {
+			t.Fatalf("EmbedStream (scatter %v) failed: %v", scatter, err)
+		}
+		embedded, err := os.ReadFile(output.Name())
+		if err != nil {
+			t.Fatalf("Reading output failed: %v", err)
+		}
+		if len(embedded) != len(carrier) || !bytes.Equal(embedded[:44], carrier[:44]) {
+			t.Fatalf("EmbedStream (scatter %v) changed the container layout", scatter)
+		}
+
+		// A reader that cannot seek, as a pipe would be
+		pipe := struct{ io.Reader }{bytes.NewReader(embedded)}
+		var extracted bytes.Buffer
+		_, err = stego.ExtractStream(&extracted, pipe, stego.ExtractOptions{Password: testPassword})
+		if scatter {
+			if err == nil {
+				t.Fatalf("Scattered extraction from a pipe should fail")
+			}
+			extracted.Reset()
+			_, err = stego.ExtractStream(&extracted, bytes.NewReader(embedded), stego.ExtractOptions{Password: testPassword})
+		}
+		if err != nil {
+			t.Fatalf("ExtractStream (scatter %v) failed: %v", scatter, err)
+		}
+		if !bytes.Equal(extracted.Bytes(), payload) {
+			t.Fatalf("ExtractStream (scatter %v) returned %q", scatter, extracted.Bytes())
+		}
+	}
+
+	// A WAV streamed with unknown sizes gets its real sizes written back
+	streamed := writeTestWAV(t, "tests/container_streamed.wav", 1, 16, 16)
+	binary.LittleEndian.PutUint32(streamed[4:], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint32(streamed[40:], 0xFFFFFFFF)
+	outputPath := filepath.Join(t.TempDir(), "output_streamed.wav")
+	output, err := os.Create(outputPath)
+	if err != nil {
+		t.Fatalf("Creating output failed: %v", err)
+	}
+	defer output.Close()
+	err = stego.EmbedStream(output, struct{ io.Reader }{bytes.NewReader(streamed)}, bytes.NewReader(payload), stego.EmbedOptions{})
+	if err != nil {
+		t.Fatalf("EmbedStream with unknown sizes failed: %v", err)
+	}
+	if pcm := readTestWAV(t, outputPath).pcm; len(pcm) != len(streamed)-44 {
+		t.Fatalf("Patched data size is %d, want %d", len(pcm), len(streamed)-44)
+	}
+	if _, err := stego.Extract(outputPath, filepath.Join(t.TempDir(), "out"), stego.ExtractOptions{}); err != nil {
+		t.Fatalf("Extraction after streaming failed: %v", err)
+	}
+
+	// Streamed data may be given a name and MIME type, read back from the header
+	var named bytes.Buffer
+	opts := stego.EmbedOptions{Password: testPassword, Encryption: true, KDF: stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000},
+		Filename: "notes.txt", MIMEType: "text/plain"}
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := stego.EmbedStream(output, bytes.NewReader(streamed), bytes.NewReader(payload), opts); err != nil {
+		t.Fatalf("EmbedStream with a file name failed: %v", err)
+	}
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	header, err := stego.ExtractStream(&named, output, stego.ExtractOptions{Password: testPassword})
+	if err != nil || !bytes.Equal(named.Bytes(), payload) {
+		t.Fatalf("ExtractStream of named data failed: %v", err)
+	}
+	if name, ok := header.Extension(stego.ExtFilename); !ok || string(name) != "notes.txt" {
+		t.Fatalf("File name not stored with the streamed data")
+	}
+	info, err := stego.Inspect(outputPath, stego.ExtractOptions{})
+	if err != nil || info.Header == nil || info.Header.Filename != "notes.txt" || info.Header.MIMEType != "text/plain" {
+		t.Fatalf("Unexpected info for named streamed data: %v, %+v", err, info)
+	}
+}
+
+// **Test 21: CLI - Capacity report matches what embed accepts**
+func TestCLI_Capacity(t *testing.T) {
+	const containerPath = "tests/container_capacity.wav"
+	const outputPath = "tests/output_capacity.wav"
+	writeTestWAV(t, containerPath, 1, 16, 16)
+
+	cmd := exec.Command("./godeep", "capacity", "-c", containerPath, "-i", testSecretFile, "--json")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI Capacity failed: %v\nOutput: %s", err, output)
+	}
+	var report stego.CapacityReport
+	if err := json.Unmarshal(output, &report); err != nil {
+		t.Fatalf("Capacity output is not JSON: %v\nOutput: %s", err, output)
+	}
+	if report.Samples != 80000 || len(report.Capacities) != 2*stego.MaxDepth {
+		t.Fatalf("Unexpected capacity report: %+v", report)
+	}
+	var usable int64
+	for _, capacity := range report.Capacities {
+		if capacity.RawBits != (report.Samples-24)*int64(capacity.Depth) || capacity.UsableBytes >= capacity.RawBits/8 {
+			t.Fatalf("Inconsistent capacity: %+v", capacity)
+		}
+		if capacity.InputFits == nil || !*capacity.InputFits {
+			t.Fatalf("Secret file should fit: %+v", capacity)
+		}
+		if capacity.Depth == 1 && capacity.Encrypted {
+			usable = capacity.UsableBytes
+		}
+	}
+
+	// Incompressible input just under and over the reported capacity
+	opts := stego.EmbedOptions{Password: testPassword, Encryption: true, KDF: stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000}}
+	for _, size := range []int64{usable - 2048, usable + 256} {
+		input := filepath.Join(t.TempDir(), "random.bin")
+		data := make([]byte, size)
+		rand.New(rand.NewSource(size)).Read(data)
+		if err := os.WriteFile(input, data, 0644); err != nil {
+			t.Fatalf("Writing input failed: %v", err)
+		}
+		err := stego.Embed([]string{input}, outputPath, containerPath, opts)
+		if size < usable && err != nil {
+			t.Fatalf("Embedding %d bytes into %d usable bytes failed: %v", size, usable, err)
+		}
+		if size > usable && !errors.Is(err, stego.ErrCapacity) {
+			t.Fatalf("Embedding %d bytes into %d usable bytes: got %v, want ErrCapacity", size, usable, err)
+		}
+	}
+}
+
+// **Test 22: CLI - Info reads the GDP header without decrypting**
+func TestCLI_Info(t *testing.T) {
+	const containerPath = "tests/container_info.wav"
+	const outputPath = "tests/output_info.wav"
+	writeTestWAV(t, containerPath, 1, 16, 16)
+
+	opts := stego.EmbedOptions{Password: testPassword, Encryption: true, KDF: stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000}, Depth: 2, Scatter: true}
+	if err := stego.Embed([]string{testSecretFile}, outputPath, containerPath, opts); err != nil {
+		t.Fatalf("Embed failed: %v", err)
+	}
+
+	inspect := func(args ...string) stego.ContainerInfo {
+		cmd := exec.Command("./godeep", append([]string{"info", "--json"}, args...)...)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("CLI Info failed: %v\nOutput: %s", err, output)
+		}
+		var info stego.ContainerInfo
+		if err := json.Unmarshal(output, &info); err != nil {
+			t.Fatalf("Info output is not JSON: %v\nOutput: %s", err, output)
+		}
+		return info
+	}
+
+	// Scattered data can only be located with the password, a wrong one finds nothing
+	info := inspect("-c", outputPath, "-p", testPassword)
+	header := info.Header
+	if !info.Present || header == nil || info.Depth != 2 || !info.Scattered || info.Samples != 80000 {
+		t.Fatalf("Unexpected info: %+v", info)
+	}
+	if header.Version != stego.GDPVersion || !header.Encrypted || header.NonceSize != 12 || header.Content != "tar" ||
+		header.KDF == nil || header.KDF.Time != 1000 || header.CiphertextSize == 0 {
+		t.Fatalf("Unexpected header: %+v", header)
+	}
+	if info := inspect("-c", outputPath); info.Present || !info.Scattered || info.Reason == "" {
+		t.Fatalf("Scattered data found without a password: %+v", info)
+	}
+	if info := inspect("-c", outputPath, "-p", "wrong"); info.Present {
+		t.Fatalf("Scattered data found with a wrong password: %+v", info)
+	}
+	if info := inspect("-c", containerPath); info.Present || len(info.Chunks) == 0 || info.Chunks[len(info.Chunks)-1] != "data" {
+		t.Fatalf("Unexpected info for a clean container: %+v", info)
+	}
+}
+
+// **Test 23: CLI - Verify detects a damaged carrier through the stored checksum**
+func TestCLI_Verify(t *testing.T) {
+	const containerPath = "tests/container_verify.wav"
+	const outputPath = "tests/output_verify.wav"
+	writeTestWAV(t, containerPath, 1, 16, 16)
+
+	verify := func(args ...string) (stego.Verification, error) {
+		cmd := exec.Command("./godeep", append([]string{"verify", "-c", outputPath, "--json"}, args...)...)
+		output, err := cmd.CombinedOutput()
+		var verification stego.Verification
+		if jsonErr := json.Unmarshal(output, &verification); err == nil && jsonErr != nil {
+			t.Fatalf("Verify output is not JSON: %v\nOutput: %s", jsonErr, output)
+		}
+		return verification, err
+	}
+
+	// A keyed digest for encrypted payloads
+	embed := exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", containerPath, "-o", outputPath, "-p", testPassword, "--kdf-time", "1000")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	if verification, err := verify("-p", testPassword); err != nil || verification.Checksum != "SHA-256" || !verification.Keyed || !verification.Encrypted {
+		t.Fatalf("Verify of an encrypted payload failed: %v, %+v", err, verification)
+	}
+
+	// A plain digest for unencrypted payloads
+	embed = exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", containerPath, "-o", outputPath, "--noencryption", "--checksum", "blake2b")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	if verification, err := verify(); err != nil || verification.Checksum != "BLAKE2b-256" || verification.Keyed {
+		t.Fatalf("Verify of an unencrypted payload failed: %v, %+v", err, verification)
+	}
+
+	// Flip one bit of the stored digest: the GDP file starts after the 24 layout samples and the digest
+	// follows the fixed fields (10 bytes), the Compression and Content fields (8) and its own type, length and algorithm (4)
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Reading output failed: %v", err)
+	}
+	samples := bytes.Index(data, []byte("data")) + 8
+	data[samples+2*(24+22*8+3)] ^= 1
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		t.Fatalf("Writing output failed: %v", err)
+	}
+	if _, err := stego.Verify(outputPath, stego.ExtractOptions{}); !errors.Is(err, stego.ErrChecksum) {
+		t.Fatalf("Verify of a damaged carrier: got %v, want ErrChecksum", err)
+	}
+	if _, err := verify(); err == nil {
+		t.Fatal("CLI Verify succeeded on a damaged carrier")
+	}
+	if _, err := stego.Extract(outputPath, "tests/extracted_verify.txt", stego.ExtractOptions{}); !errors.Is(err, stego.ErrChecksum) {
+		t.Fatalf("Extract of a damaged carrier: got %v, want ErrChecksum", err)
+	}
+
+	// Without encryption or a digest there is nothing to check against
+	embed = exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", containerPath, "-o", outputPath, "--noencryption", "--checksum", "none")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	if _, err := verify(); err == nil {
+		t.Fatal("CLI Verify succeeded without a checksum")
+	}
+}
+
+// **Test 24: CLI - Error correction repairs flipped LSBs**
+func TestCLI_ErrorCorrection(t *testing.T) {
+	const containerPath = "tests/container_fec.wav"
+	const outputPath = "tests/output_fec.wav"
+	const extractedPath = "tests/extracted_fec.txt"
+	writeTestWAV(t, containerPath, 1, 16, 16)
+
+	embed := exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", containerPath, "-o", outputPath, "--noencryption", "--fec", "16")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+
+	// Flip scattered LSBs and a burst of consecutive ones, all within the frame
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Reading output failed: %v", err)
+	}
+	samples := bytes.Index(data, []byte("data")) + 8
+	for i := 24; i < 20000; i += 997 {
+		data[samples+2*i] ^= 1
+	}
+	// One copy of each layout bit, clearing the FEC flag and changing the depth in the first copy
+	for _, i := range []int{0, 6, 9, 12, 18, 23} {
+		data[samples+2*i] ^= 1
+	}
+	for i := 400; i < 440; i++ {
+		data[samples+2*i] ^= 1
+	}
+	if err := os.WriteFile(outputPath, data, 0644); err != nil {
+		t.Fatalf("Writing output failed: %v", err)
+	}
+
+	cmd := exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "--noencryption")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI Extract failed: %v\nOutput: %s", err, output)
+	}
+	if !strings.Contains(string(output), "Error correction repaired") {
+		t.Fatalf("Extract did not report the corrections\nOutput: %s", output)
+	}
+	original, _ := os.ReadFile(testSecretFile)
+	extracted, err := os.ReadFile(extractedPath)
+	if err != nil || !bytes.Equal(original, extracted) {
+		t.Fatalf("Extracted file differs from the original: %v", err)
+	}
+
+	// Without error correction the same damage is fatal
+	embed = exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", containerPath, "-o", outputPath, "--noencryption")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	data, _ = os.ReadFile(outputPath)
+	for i := 400; i < 440; i++ {
+		data[samples+2*i] ^= 1
+	}
+	os.WriteFile(outputPath, data, 0644)
+	if _, err := stego.Open(outputPath, stego.ExtractOptions{}); err == nil {
+		t.Fatal("Damaged container without error correction was read")
+	}
+}
+
+// **Test 25: CLI - Sync blocks survive a trimmed start**
+func TestCLI_SyncBlocks(t *testing.T) {
+	const containerPath = "tests/container_sync.wav"
+	const outputPath = "tests/output_sync.wav"
+	const extractedPath = "tests/extracted_sync.txt"
+	writeTestWAV(t, containerPath, 1, 16, 16)
+	original, _ := os.ReadFile(testSecretFile)
+
+	// trim cuts frames from the start of the sample data and fixes the chunk sizes
+	trim := func(frames int) {
+		data, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("Reading output failed: %v", err)
+		}
+		chunk := bytes.Index(data, []byte("data"))
+		size := binary.LittleEndian.Uint32(data[chunk+4:]) - uint32(frames*4)
+		trimmed := append(data[:chunk+8:chunk+8], data[chunk+8+frames*4:]...)
+		binary.LittleEndian.PutUint32(trimmed[chunk+4:], size)
+		binary.LittleEndian.PutUint32(trimmed[4:], uint32(len(trimmed)-8))
+		if err := os.WriteFile(outputPath, trimmed, 0644); err != nil {
+			t.Fatalf("Writing output failed: %v", err)
+		}
+	}
+
+	// Error correction rebuilds the blocks lost with the start
+	embed := exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", containerPath, "-o", outputPath, "--noencryption", "--sync", "--fec", "96", "--depth", "2")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	trim(1500)
+	cmd := exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "--noencryption")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI Extract failed: %v\nOutput: %s", err, output)
+	}
+	if !strings.Contains(string(output), "were missing and have been rebuilt") {
+		t.Fatalf("Extract did not report the missing blocks\nOutput: %s", output)
+	}
+	extracted, err := os.ReadFile(extractedPath)
+	if err != nil || !bytes.Equal(original, extracted) {
+		t.Fatalf("Extracted file differs from the original: %v", err)
+	}
+
+	// Without it the missing blocks are named, while the header survives in its copy
+	embed = exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", containerPath, "-o", outputPath, "--noencryption", "--sync")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	trim(4000)
+	_, err = stego.Open(outputPath, stego.ExtractOptions{})
+	if !errors.Is(err, stego.ErrIncomplete) || !strings.Contains(err.Error(), "blocks 2 of") {
+		t.Fatalf("Open of a trimmed container: got %v, want ErrIncomplete naming block 2", err)
+	}
+	info, err := stego.Inspect(outputPath, stego.ExtractOptions{})
+	if err != nil || !info.Present || info.Blocks == 0 || len(info.Missing) != 1 || info.Missing[0] != 1 {
+		t.Fatalf("Unexpected info for a trimmed container: %v, %+v", err, info)
+	}
+}
+
+// **Test 26: CLI - Split one payload across several containers**
+func TestCLI_SplitContainers(t *testing.T) {
+	dir := t.TempDir()
+	setDir := filepath.Join(dir, "set")
+	outputDir := filepath.Join(dir, "output")
+	secretPath := filepath.Join(dir, "secret.bin")
+	extractedPath := filepath.Join(dir, "extracted.bin")
+	if err := os.Mkdir(setDir, 0755); err != nil {
+		t.Fatalf("Creating the container directory failed: %v", err)
+	}
+	for _, name := range []string{"a.wav", "b.wav", "c.wav"} {
+		writeTestWAV(t, filepath.Join(setDir, name), 1, 16, 16)
+	}
+
+	// Random data does not compress, so it needs more than one 10 KB container
+	original := make([]byte, 16000)
+	rand.New(rand.NewSource(26)).Read(original)
+	if err := os.WriteFile(secretPath, original, 0644); err != nil {
+		t.Fatalf("Writing the payload failed: %v", err)
+	}
+
+	embed := exec.Command("./godeep", "embed", "-i", secretPath, "-c", setDir, "-o", outputDir, "--noencryption")
+	output, err := embed.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	if !strings.Contains(string(output), "needs at least 2 of them") {
+		t.Fatalf("Embed did not say how many containers are needed\nOutput: %s", output)
+	}
+
+	// The set is reassembled whatever order the containers are given in
+	a, b, c := filepath.Join(outputDir, "a.wav"), filepath.Join(outputDir, "b.wav"), filepath.Join(outputDir, "c.wav")
+	cmd := exec.Command("./godeep", "extract", "-c", c, "-c", a, "-c", b, "-o", extractedPath, "--noencryption")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Extract failed: %v\nOutput: %s", err, output)
+	}
+	extracted, err := os.ReadFile(extractedPath)
+	if err != nil || !bytes.Equal(original, extracted) {
+		t.Fatalf("Extracted file differs from the original: %v", err)
+	}
+
+	// A missing container is named
+	_, err = stego.OpenSet([]string{c, a}, stego.ExtractOptions{})
+	if !errors.Is(err, stego.ErrIncomplete) || !strings.Contains(err.Error(), "containers 2 of 3") {
+		t.Fatalf("OpenSet without the second container: got %v, want ErrIncomplete naming it", err)
+	}
+	info, err := stego.Inspect(b, stego.ExtractOptions{})
+	if err != nil || !info.Present || info.Piece == nil || info.Piece.Index != 1 || info.Piece.Count != 3 || info.Header != nil {
+		t.Fatalf("Unexpected info for the second piece: %v, %+v", err, info)
+	}
+
+	// Two containers are enough, but not for twice as much data
+	_, err = stego.EmbedSet([]string{secretPath}, []string{a, b}, []string{filepath.Join(setDir, "a.wav"), filepath.Join(setDir, "b.wav")}, stego.EmbedOptions{})
+	if err != nil {
+		t.Fatalf("EmbedSet across two containers failed: %v", err)
+	}
+	more := make([]byte, 32000)
+	rand.New(rand.NewSource(27)).Read(more)
+	os.WriteFile(secretPath, more, 0644)
+	_, err = stego.EmbedSet([]string{secretPath}, []string{a, b}, []string{filepath.Join(setDir, "a.wav"), filepath.Join(setDir, "b.wav")}, stego.EmbedOptions{})
+	if !errors.Is(err, stego.ErrCapacity) || !strings.Contains(err.Error(), "more containers") {
+		t.Fatalf("EmbedSet of too much data: got %v, want ErrCapacity saying more containers are needed", err)
+	}
+}
+
+// **Test 27: CLI - Any k of n key shares recover the payload**
+func TestCLI_KeyShares(t *testing.T) {
+	dir := t.TempDir()
+	setDir := filepath.Join(dir, "set")
+	outputDir := filepath.Join(dir, "output")
+	extractedPath := filepath.Join(dir, "extracted.md")
+	if err := os.Mkdir(setDir, 0755); err != nil {
+		t.Fatalf("Creating the container directory failed: %v", err)
+	}
+	for _, name := range []string{"a.wav", "b.wav", "c.wav"} {
+		writeTestWAV(t, filepath.Join(setDir, name), 1, 16, 16)
+	}
+	original, _ := os.ReadFile(testSecretFile)
+
+	// No password is needed, the shares are the key
+	embed := exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", setDir, "-o", outputDir, "--threshold", "2")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	a, b, c := filepath.Join(outputDir, "a.wav"), filepath.Join(outputDir, "b.wav"), filepath.Join(outputDir, "c.wav")
+	for _, pair := range [][]string{{a, b}, {c, b}, {a, c}} {
+		cmd := exec.Command("./godeep", "extract", "-c", pair[0], "-c", pair[1], "-o", extractedPath)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("CLI Extract from %v failed: %v\nOutput: %s", pair, err, output)
+		}
+		extracted, err := os.ReadFile(extractedPath)
+		if err != nil || !bytes.Equal(original, extracted) {
+			t.Fatalf("Extracted file from %v differs from the original: %v", pair, err)
+		}
+	}
+
+	// One share is not enough, and says how many are
+	_, err := stego.OpenSet([]string{b}, stego.ExtractOptions{})
+	if !errors.Is(err, stego.ErrIncomplete) || !strings.Contains(err.Error(), "2 are needed") {
+		t.Fatalf("OpenSet with one share: got %v, want ErrIncomplete asking for 2", err)
+	}
+	info, err := stego.Inspect(c, stego.ExtractOptions{})
+	if err != nil || info.Header == nil || info.Header.Share == nil || info.Header.Share.Index != 3 || info.Header.Share.Threshold != 2 {
+		t.Fatalf("Unexpected info for the third share: %v, %+v", err, info)
+	}
+
+	// A threshold of 1 would store the key in the clear
+	err = stego.EmbedShares([]string{testSecretFile}, []string{a, b}, []string{filepath.Join(setDir, "a.wav"), filepath.Join(setDir, "b.wav")}, 1, stego.EmbedOptions{})
+	if err == nil || !strings.Contains(err.Error(), "invalid threshold 1") {
+		t.Fatalf("EmbedShares with a threshold of 1: got %v, want an invalid threshold error", err)
+	}
+
+	// With a password the shares alone are not enough
+	err = stego.EmbedShares([]string{testSecretFile}, []string{a, b}, []string{filepath.Join(setDir, "a.wav"), filepath.Join(setDir, "b.wav")}, 2,
+		stego.EmbedOptions{Password: "test123", KDF: stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000}})
+	if err != nil {
+		t.Fatalf("EmbedShares with a password failed: %v", err)
+	}
+	if _, err := stego.OpenSet([]string{a, b}, stego.ExtractOptions{}); !errors.Is(err, stego.ErrAuthFailed) {
+		t.Fatalf("OpenSet without the password: got %v, want ErrAuthFailed", err)
+	}
+	if _, err := stego.OpenSet([]string{b, a}, stego.ExtractOptions{Password: "test123"}); err != nil {
+		t.Fatalf("OpenSet with the password failed: %v", err)
+	}
+}
+
+// **Test 28: CLI - Payloads encrypted to X25519 recipients**
+func TestCLI_Recipients(t *testing.T) {
+	const containerPath = "tests/container_recipients.wav"
+	const outputPath = "tests/output_recipients.wav"
+	const extractedPath = "tests/extracted_recipients.txt"
+	writeTestWAV(t, containerPath, 1, 16, 16)
+	original, _ := os.ReadFile(testSecretFile)
+	dir := t.TempDir()
+
+	// One recipient is given as a key, the other in a file, its identity made by keygen
+	alice, err := stego.GenerateIdentity()
+	if err != nil {
+		t.Fatalf("GenerateIdentity failed: %v", err)
+	}
+	bobPath := filepath.Join(dir, "bob.txt")
+	if output, err := exec.Command("./godeep", "keygen", "-o", bobPath).CombinedOutput(); err != nil {
+		t.Fatalf("CLI Keygen failed: %v\nOutput: %s", err, output)
+	}
+	keys, err := stego.ReadKeys(bobPath)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("ReadKeys on the keygen output: %v, %d keys", err, len(keys))
+	}
+	bob, err := stego.ParseIdentity(keys[0])
+	if err != nil {
+		t.Fatalf("ParseIdentity failed: %v", err)
+	}
+	recipientsPath := filepath.Join(dir, "recipients.txt")
+	os.WriteFile(recipientsPath, []byte("# bob\n"+bob.Recipient().String()+"\n"), 0644)
+	embed := exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", containerPath, "-o", outputPath,
+		"-r", alice.Recipient().String(), "-r", recipientsPath)
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+
+	// Either identity opens it, no password needed
+	cmd := exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "--identity", bobPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Extract failed: %v\nOutput: %s", err, output)
+	}
+	extracted, err := os.ReadFile(extractedPath)
+	if err != nil || !bytes.Equal(original, extracted) {
+		t.Fatalf("Extracted file differs from the original: %v", err)
+	}
+	if _, err := stego.Open(outputPath, stego.ExtractOptions{Identities: []*stego.Identity{alice}}); err != nil {
+		t.Fatalf("Open with the first identity failed: %v", err)
+	}
+
+	// Anyone else is turned away
+	eve, _ := stego.GenerateIdentity()
+	if _, err := stego.Open(outputPath, stego.ExtractOptions{Identities: []*stego.Identity{eve}}); !errors.Is(err, stego.ErrAuthFailed) {
+		t.Fatalf("Open with a stranger's identity: got %v, want ErrAuthFailed", err)
+	}
+	info, err := stego.Inspect(outputPath, stego.ExtractOptions{})
+	if err != nil || info.Header == nil || info.Header.Recipients != 2 {
+		t.Fatalf("Unexpected info for a container with two recipients: %v, %+v", err, info)
+	}
+
+	// With a password too, the identity alone is not enough
+	opts := stego.EmbedOptions{Password: "test123", KDF: stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000}, Recipients: []*stego.Recipient{alice.Recipient()}}
+	if err := stego.Embed([]string{testSecretFile}, outputPath, containerPath, opts); err != nil {
+		t.Fatalf("Embed to a recipient with a password failed: %v", err)
+	}
+	if _, err := stego.Open(outputPath, stego.ExtractOptions{Identities: []*stego.Identity{alice}}); !errors.Is(err, stego.ErrAuthFailed) {
+		t.Fatalf("Open without the password: got %v, want ErrAuthFailed", err)
+	}
+	if _, err := stego.Open(outputPath, stego.ExtractOptions{Password: "test123", Identities: []*stego.Identity{alice}}); err != nil {
+		t.Fatalf("Open with the identity and the password failed: %v", err)
+	}
+}
+
+// **Test 29: CLI - Signed payloads name their sender**
+func TestCLI_Signatures(t *testing.T) {
+	const outputPath = "tests/output_signed.wav"
+	const extractedPath = "tests/extracted_signed.txt"
+	original, _ := os.ReadFile(testSecretFile)
+	dir := t.TempDir()
+
+	// keygen --sign writes the signing key, the public key is on its first line
+	keyPath := filepath.Join(dir, "sign.txt")
+	if output, err := exec.Command("./godeep", "keygen", "--sign", "-o", keyPath).CombinedOutput(); err != nil {
+		t.Fatalf("CLI Keygen failed: %v\nOutput: %s", err, output)
+	}
+	keys, err := stego.ReadKeys(keyPath)
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("ReadKeys on the keygen output: %v, %d keys", err, len(keys))
+	}
+	signingKey, err := stego.ParseSigningKey(keys[0])
+	if err != nil {
+		t.Fatalf("ParseSigningKey failed: %v", err)
+	}
+	signer := signingKey.Public()
+
+	embed := exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", testContainerWAV, "-o", outputPath,
+		"-p", testPassword, "--kdf-time", "1000", "--sign-key", keyPath)
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	cmd := exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "-p", testPassword,
+		"--signer-pubkey", signer.String())
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("CLI Extract failed: %v\nOutput: %s", err, output)
+	}
+	if !strings.Contains(string(output), "Signature verified") {
+		t.Errorf("Extract did not report the signature:\n%s", output)
+	}
+	extracted, err := os.ReadFile(extractedPath)
+	if err != nil || !bytes.Equal(original, extracted) {
+		t.Fatalf("Extracted file differs from the original: %v", err)
+	}
+
+	// The signer is reported without a key to check against, but info does not give it away
+	payload, err := stego.Open(outputPath, stego.ExtractOptions{Password: testPassword})
+	if err != nil || payload.Signer == nil || !payload.Signer.Equal(signer) {
+		t.Fatalf("Open without signers: %v, signer %v", err, payload)
+	}
+	info, err := stego.Inspect(outputPath, stego.ExtractOptions{})
+	if err != nil || info.Header == nil || !info.Header.Signed || info.Header.Signer != "" {
+		t.Fatalf("Unexpected info for a signed container: %v, %+v", err, info)
+	}
+	output, err = exec.Command("./godeep", "info", "-c", outputPath).CombinedOutput()
+	if err != nil || strings.Contains(string(output), signer.String()) {
+		t.Fatalf("Info shows the signer of an encrypted payload: %v\n%s", err, output)
+	}
+
+	// Without encryption the signature is in the clear, and info shows it unchecked
+	signed := stego.EmbedOptions{SignKey: signingKey}
+	if err := stego.Embed([]string{testSecretFile}, outputPath, testContainerWAV, signed); err != nil {
+		t.Fatalf("Unencrypted signed embed failed: %v", err)
+	}
+	info, err = stego.Inspect(outputPath, stego.ExtractOptions{})
+	if err != nil || info.Header == nil || info.Header.Signer != signer.String() {
+		t.Fatalf("Unexpected info for an unencrypted signed container: %v, %+v", err, info)
+	}
+	if payload, err := stego.Open(outputPath, stego.ExtractOptions{}); err != nil || payload.Signer == nil || !payload.Signer.Equal(signer) {
+		t.Fatalf("Open of an unencrypted signed payload: %v", err)
+	}
+
+	// Another key, or no signature at all, is refused when a signer is expected
+	other, _ := stego.GenerateSigningKey()
+	opts := stego.ExtractOptions{Password: testPassword, Signers: []*stego.VerifyingKey{other.Public()}}
+	if _, err := stego.Open(outputPath, opts); !errors.Is(err, stego.ErrBadSignature) {
+		t.Fatalf("Open with another signer: got %v, want ErrBadSignature", err)
+	}
+	if err := stego.Embed([]string{testSecretFile}, outputPath, testContainerWAV, stego.EmbedOptions{}); err != nil {
+		t.Fatalf("Unsigned embed failed: %v", err)
+	}
+	opts = stego.ExtractOptions{Signers: []*stego.VerifyingKey{signer}}
+	if _, err := stego.Verify(outputPath, opts); !errors.Is(err, stego.ErrBadSignature) {
+		t.Fatalf("Verify of an unsigned payload: got %v, want ErrBadSignature", err)
+	}
+}
+
+// **Test 30: CLI - A hidden payload behind a decoy opens with its own password**
+func TestCLI_HiddenPayload(t *testing.T) {
+	const outputPath = "tests/output_hidden.wav"
+	const extractedPath = "tests/extracted_hidden.txt"
+	original, _ := os.ReadFile(testSecretFile)
+	dir := t.TempDir()
+	decoyPath := filepath.Join(dir, "shopping.txt")
+	decoy := []byte("eggs, milk, bread\n")
+	os.WriteFile(decoyPath, decoy, 0644)
+
+	embed := exec.Command("./godeep", "embed", "-i", decoyPath, "-c", testContainerWAV, "-o", outputPath,
+		"-p", "decoy", "--hidden", testSecretFile, "--hidden-password", "hidden", "--kdf-time", "1000")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+
+	// Each password opens its own payload
+	cmd := exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "-p", "hidden")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Extract with the hidden password failed: %v\nOutput: %s", err, output)
+	}
+	extracted, err := os.ReadFile(extractedPath)
+	if err != nil || !bytes.Equal(original, extracted) {
+		t.Fatalf("Extracted hidden file differs from the original: %v", err)
+	}
+	payload, err := stego.Open(outputPath, stego.ExtractOptions{Password: "decoy"})
+	if err != nil || payload.Hidden || len(payload.Entries) != 1 || !bytes.Equal(payload.Entries[0].Data, decoy) {
+		t.Fatalf("Open with the decoy password: %v, %+v", err, payload)
+	}
+	if _, err := stego.Open(outputPath, stego.ExtractOptions{Password: "neither"}); !errors.Is(err, stego.ErrAuthFailed) {
+		t.Fatalf("Open with a wrong password: got %v, want ErrAuthFailed", err)
+	}
+
+	// Streaming extraction finds it too when the carrier can seek
+	carrier, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer carrier.Close()
+	var archive bytes.Buffer
+	if _, err := stego.ExtractStream(&archive, carrier, stego.ExtractOptions{Password: "hidden"}); err != nil {
+		t.Fatalf("ExtractStream with the hidden password failed: %v", err)
+	}
+
+	// The decoy may carry error correction, the hidden payload is found after its parity
+	opts := stego.EmbedOptions{Password: "decoy", Encryption: true, KDF: stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000}, FEC: 16}
+	if err := stego.EmbedHidden([]string{decoyPath}, []string{testSecretFile}, outputPath, testContainerWAV, "hidden", opts); err != nil {
+		t.Fatalf("EmbedHidden with error correction failed: %v", err)
+	}
+	payload, err = stego.Open(outputPath, stego.ExtractOptions{Password: "hidden"})
+	if err != nil || !payload.Hidden || !bytes.Equal(payload.Entries[0].Data, original) {
+		t.Fatalf("Open of the hidden payload behind a corrected decoy: %v", err)
+	}
+	opts.Scatter = true
+	if err := stego.EmbedHidden([]string{decoyPath}, []string{testSecretFile}, outputPath, testContainerWAV, "hidden", opts); err == nil {
+		t.Fatal("EmbedHidden accepted a scattered decoy")
+	}
+}
+
+// **Test 31: CLI - Stealth mode leaves nothing in the clear**
+func TestCLI_Stealth(t *testing.T) {
+	const outputPath = "tests/output_stealth.wav"
+	const extractedPath = "tests/extracted_stealth.txt"
+	original, _ := os.ReadFile(testSecretFile)
+
+	embed := exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", testContainerWAV, "-o", outputPath,
+		"-p", testPassword, "-d", "2", "--stealth", "--kdf-time", "1000")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+
+	// Without the password there is no layout byte or header to find
+	info, err := stego.Inspect(outputPath, stego.ExtractOptions{})
+	if err != nil || info.Present {
+		t.Fatalf("Inspect without the password found data in a stealth container: %v, %+v", err, info)
+	}
+	if _, err := stego.Open(outputPath, stego.ExtractOptions{Password: "wrong"}); !errors.Is(err, stego.ErrBadMagic) {
+		t.Fatalf("Open with a wrong password: got %v, want ErrBadMagic", err)
+	}
+
+	// The password finds the depth by trial decryption
+	cmd := exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "-p", testPassword)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Extract failed: %v\nOutput: %s", err, output)
+	}
+	extracted, err := os.ReadFile(extractedPath)
+	if err != nil || !bytes.Equal(original, extracted) {
+		t.Fatalf("Extracted file differs from the original: %v", err)
+	}
+	info, err = stego.Inspect(outputPath, stego.ExtractOptions{Password: testPassword})
+	if err != nil || !info.Stealth || info.Depth != 2 || info.Header == nil || !info.Header.Encrypted {
+		t.Fatalf("Unexpected info for a stealth container with its password: %v, %+v", err, info)
+	}
+	carrier, err := os.Open(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer carrier.Close()
+	var archive bytes.Buffer
+	if _, err := stego.ExtractStream(&archive, carrier, stego.ExtractOptions{Password: testPassword}); err != nil {
+		t.Fatalf("ExtractStream of a stealth container failed: %v", err)
+	}
+
+	// Stealth mode needs a password even for an unencrypted payload
+	opts := stego.EmbedOptions{Stealth: true}
+	if err := stego.Embed([]string{testSecretFile}, outputPath, testContainerWAV, opts); err == nil {
+		t.Fatal("Embed accepted stealth mode without a password")
+	}
+	opts.Password = testPassword
+	if err := stego.Embed([]string{testSecretFile}, outputPath, testContainerWAV, opts); err != nil {
+		t.Fatalf("Unencrypted stealth embed failed: %v", err)
+	}
+	payload, err := stego.Open(outputPath, stego.ExtractOptions{Password: testPassword})
+	if err != nil || !payload.Stealth || payload.Header.Encryption {
+		t.Fatalf("Open of an unencrypted stealth payload: %v", err)
+	}
+
+	// A payload damaged beyond repair is reported as it is, not looked for in stealth mode
+	opts = stego.EmbedOptions{Password: testPassword, FEC: 2}
+	if err := stego.Embed([]string{testSecretFile}, outputPath, testContainerWAV, opts); err != nil {
+		t.Fatalf("Embed with error correction failed: %v", err)
+	}
+	damaged := readTestWAV(t, outputPath)
+	for i := 24 + 200*8; i < 24+1000*8; i++ {
+		damaged.pcm[i*damaged.sampleSize] ^= 0x01
+	}
+	if err := os.WriteFile(outputPath, damaged.file, 0644); err != nil {
+		t.Fatalf("Writing container failed: %v", err)
+	}
+	var logged []string
+	logf := func(format string, args ...any) { logged = append(logged, fmt.Sprintf(format, args...)) }
+	_, err = stego.Open(outputPath, stego.ExtractOptions{Password: testPassword, Logf: logf})
+	if err == nil || errors.Is(err, stego.ErrBadMagic) {
+		t.Fatalf("Open of a payload damaged beyond repair: got %v, want the error correction error", err)
+	}
+	for _, line := range logged {
+		if strings.Contains(line, "stealth GDP file") {
+			t.Fatalf("Open of a damaged payload tried stealth mode: %s", line)
+		}
+	}
+}
+
+// **Test 32: CLI - Padding hides the payload size**
+func TestCLI_Padding(t *testing.T) {
+	const outputPath = "tests/output_padding.wav"
+	const extractedPath = "tests/extracted_padding.txt"
+	original, _ := os.ReadFile(testSecretFile)
+
+	// A bucket rounds the plaintext up, the tag comes on top
+	opts := stego.EmbedOptions{Password: testPassword, Encryption: true, KDF: stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000}, Padding: 4096}
+	if err := stego.Embed([]string{testSecretFile}, outputPath, testContainerWAV, opts); err != nil {
+		t.Fatalf("Embed with a padding bucket failed: %v", err)
+	}
+	info, err := stego.Inspect(outputPath, stego.ExtractOptions{})
+	if err != nil || info.Header == nil || !info.Header.Padded || info.Header.CiphertextSize != 4096+16 {
+		t.Fatalf("Unexpected header for a padded payload: %v, %+v", err, info.Header)
+	}
+	payload, err := stego.Open(outputPath, stego.ExtractOptions{Password: testPassword})
+	if err != nil || len(payload.Entries) != 1 || !bytes.Equal(payload.Entries[0].Data, original) {
+		t.Fatalf("Open of a padded payload failed: %v", err)
+	}
+
+	// Filling the container leaves no bit of it unused
+	embed := exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", testContainerWAV, "-o", outputPath,
+		"-p", testPassword, "--pad", "fill", "--kdf-time", "1000")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	report, err := stego.ContainerCapacity(testContainerWAV, nil, 0, stego.EmbedOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err = stego.Inspect(outputPath, stego.ExtractOptions{})
+	if err != nil || info.Header == nil {
+		t.Fatalf("Inspect of a filled container failed: %v", err)
+	}
+	if used := int64(info.Header.HeaderSize) + int64(info.Header.CiphertextSize); used != report.Capacities[0].RawBits/8 {
+		t.Fatalf("Filled GDP file is %d bytes, the container holds %d", used, report.Capacities[0].RawBits/8)
+	}
+	cmd := exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "-p", testPassword)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Extract failed: %v\nOutput: %s", err, output)
+	}
+	extracted, err := os.ReadFile(extractedPath)
+	if err != nil || !bytes.Equal(original, extracted) {
+		t.Fatalf("Extracted file differs from the original: %v", err)
+	}
+
+	// Only Embed knows the container's size before sealing the payload
+	opts.Padding = stego.PadFill
+	carrier, err := os.Open(testContainerWAV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer carrier.Close()
+	output, err := os.Create(filepath.Join(t.TempDir(), "output.wav"))
+	if err != nil {
+		t.Fatalf("Creating output failed: %v", err)
+	}
+	defer output.Close()
+	if err := stego.EmbedStream(output, carrier, bytes.NewReader(original), opts); err == nil {
+		t.Fatal("EmbedStream accepted padding to fill")
+	}
+	for input, want := range map[string]int{"fill": stego.PadFill, "64K": 64 << 10, "1MiB": 1 << 20, "512": 512, "none": 0} {
+		if got, err := stego.ParsePadding(input); err != nil || got != want {
+			t.Fatalf("ParsePadding(%q) = %d, %v, want %d", input, got, err, want)
+		}
+	}
+}
+
+// **Test 33: CLI - XChaCha20-Poly1305 as an alternative cipher**
+func TestCLI_XChaCha20(t *testing.T) {
+	const outputPath = "tests/output_xchacha20.wav"
+	const extractedPath = "tests/extracted_xchacha20.txt"
+	original, _ := os.ReadFile(testSecretFile)
+
+	embed := exec.Command("./godeep", "embed", "-i", testSecretFile, "-c", testContainerWAV, "-o", outputPath,
+		"-p", testPassword, "--cipher", "xchacha20", "--kdf-time", "1000")
+	if output, err := embed.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Embed failed: %v\nOutput: %s", err, output)
+	}
+	info, err := stego.Inspect(outputPath, stego.ExtractOptions{})
+	if err != nil || info.Header == nil || info.Header.Cipher != "XChaCha20-Poly1305" || info.Header.NonceSize != 24 {
+		t.Fatalf("Unexpected header for an XChaCha20-Poly1305 payload: %v, %+v", err, info.Header)
+	}
+	cmd := exec.Command("./godeep", "extract", "-c", outputPath, "-o", extractedPath, "-p", testPassword)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("CLI Extract failed: %v\nOutput: %s", err, output)
+	}
+	extracted, err := os.ReadFile(extractedPath)
+	if err != nil || !bytes.Equal(original, extracted) {
+		t.Fatalf("Extracted file differs from the original: %v", err)
+	}
+	if _, err := stego.Open(outputPath, stego.ExtractOptions{Password: "wrong"}); !errors.Is(err, stego.ErrAuthFailed) {
+		t.Fatalf("Open with a wrong password: got %v, want ErrAuthFailed", err)
+	}
+
+	// The cipher's larger nonce and recipients are accounted for when filling the container
+	alice, err := stego.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := stego.EmbedOptions{Cipher: stego.CipherXChaCha20, Recipients: []*stego.Recipient{alice.Recipient()}, Padding: stego.PadFill}
+	if err := stego.Embed([]string{testSecretFile}, outputPath, testContainerWAV, opts); err != nil {
+		t.Fatalf("Embed to a recipient with XChaCha20-Poly1305 failed: %v", err)
+	}
+	payload, err := stego.Open(outputPath, stego.ExtractOptions{Identities: []*stego.Identity{alice}})
+	if err != nil || payload.Header.Cipher != stego.CipherXChaCha20 {
+		t.Fatalf("Open of an XChaCha20-Poly1305 payload failed: %v", err)
+	}
+
+	// The helpers dispatch on the cipher recorded in the header
+	key := generateKey()
+	compressed, err := stego.CompressXZ(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, nonce, err := stego.Encrypt(compressed, key, stego.CipherXChaCha20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := stego.DecryptAndDecompress(ciphertext, key, nonce, stego.CipherXChaCha20)
+	if err != nil || !bytes.Equal(decrypted, original) {
+		t.Fatalf("DecryptAndDecompress with XChaCha20-Poly1305 failed: %v", err)
+	}
+	if _, err := stego.DecryptAndDecompress(ciphertext, key, nonce, stego.CipherAESGCM); err == nil {
+		t.Fatal("DecryptAndDecompress opened XChaCha20-Poly1305 data as AES-GCM")
+	}
+	gdpFile, err := stego.MakeGDPFile(stego.GDPFile{Encryption: true, Cipher: 7, Nonce: nonce, Ciphertext: ciphertext})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stego.ParseGDPFile(gdpFile, false); !errors.Is(err, stego.ErrUnsupportedFormat) {
+		t.Fatalf("ParseGDPFile of an unknown cipher: got %v, want ErrUnsupportedFormat", err)
+	}
+	if _, err := stego.ParseCipher("rot13"); err == nil {
+		t.Fatal("ParseCipher accepted an unknown cipher")
+	}
+}
+
+// **Test 34: Noise carrying the split flag is not taken for a piece**
+func TestSplitPieceInNoise(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "noise.wav")
+	wav := writeTestWAV(t, path, 1, 16, 16)
+
+	// setLSBs writes data at one bit per 16-bit sample from the given sample on
+	setLSBs := func(start int, data []byte) {
+		for i := 0; i < len(data)*8; i++ {
+			index := 44 + (start+i)*2
+			wav[index] = wav[index]&^1 | (data[i/8]>>(i%8))&1
+		}
+	}
+	const layout = 0x11 // Depth 1, split set
+	for seed := int64(0); seed < 50; seed++ {
+		rand.New(rand.NewSource(seed)).Read(wav[44:])
+		setLSBs(0, []byte{layout, layout, layout})
+		if err := os.WriteFile(path, wav, 0644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := stego.Inspect(path, stego.ExtractOptions{})
+		if err != nil || info.Present || info.Piece != nil {
+			t.Fatalf("Inspect of noise (seed %d): %v, %+v", seed, err, info)
+		}
+		if _, err := stego.Open(path, stego.ExtractOptions{}); !errors.Is(err, stego.ErrBadMagic) {
+			t.Fatalf("Open of noise (seed %d): got %v, want ErrBadMagic", seed, err)
+		}
+	}
+
+	// A piece header with a valid CRC but an impossible total is refused without panicking
+	header := make([]byte, 28)
+	binary.LittleEndian.PutUint16(header[10:], 1)
+	binary.LittleEndian.PutUint64(header[12:], 1<<62)
+	binary.LittleEndian.PutUint32(header[20:], 16)
+	binary.LittleEndian.PutUint32(header[24:], crc32.ChecksumIEEE(header[:24]))
+	setLSBs(24, append(header, make([]byte, 16)...))
+	if err := os.WriteFile(path, wav, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stego.Open(path, stego.ExtractOptions{}); err == nil || !strings.Contains(err.Error(), "bad total size") {
+		t.Fatalf("Open of a piece with a bogus total: got %v", err)
+	}
+}
+
+// **Test 35: Capacity counts the header fields the options add**
+func TestCapacityOverhead(t *testing.T) {
+	outputDir := t.TempDir()
+	signer, err := stego.GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, err := stego.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := stego.GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kdf := stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000}
+
+	for name, test := range map[string]struct {
+		opts      stego.EmbedOptions
+		threshold int
+	}{
+		"password":     {opts: stego.EmbedOptions{Password: testPassword, Encryption: true, KDF: kdf, Checksum: stego.ChecksumSHA256}},
+		"unencrypted":  {opts: stego.EmbedOptions{Checksum: stego.ChecksumBLAKE2b}},
+		"signed":       {opts: stego.EmbedOptions{Password: testPassword, Encryption: true, KDF: kdf, Checksum: stego.ChecksumBLAKE2b, SignKey: signer}},
+		"recipients":   {opts: stego.EmbedOptions{Recipients: []*stego.Recipient{alice.Recipient(), bob.Recipient()}, Checksum: stego.ChecksumSHA256}},
+		"key shares":   {opts: stego.EmbedOptions{Encryption: true, Checksum: stego.ChecksumSHA256}, threshold: 2},
+		"padded, fill": {opts: stego.EmbedOptions{Password: testPassword, Encryption: true, KDF: kdf, Padding: stego.PadFill}},
+		"xchacha20":    {opts: stego.EmbedOptions{Password: testPassword, Encryption: true, KDF: kdf, Cipher: stego.CipherXChaCha20, Checksum: stego.ChecksumSHA256}},
+	} {
+		report, err := stego.ContainerCapacity(testContainerWAV, []string{testSecretFile}, test.threshold, test.opts)
+		if err != nil {
+			t.Fatalf("%s: ContainerCapacity failed: %v", name, err)
+		}
+		encrypted := test.opts.Encryption || len(test.opts.Recipients) > 0
+		var capacity stego.Capacity
+		for _, c := range report.Capacities {
+			if c.Depth == 1 && c.Encrypted == encrypted {
+				capacity = c
+			}
+		}
+
+		output := filepath.Join(outputDir, "output.wav")
+		if test.threshold != 0 {
+			outputs := []string{output, filepath.Join(outputDir, "second.wav")}
+			err = stego.EmbedShares([]string{testSecretFile}, outputs, []string{testContainerWAV, testContainerWAV}, test.threshold, test.opts)
+		} else {
+			err = stego.Embed([]string{testSecretFile}, output, testContainerWAV, test.opts)
+		}
+		if err != nil {
+			t.Fatalf("%s: embed failed: %v", name, err)
+		}
+		info, err := stego.Inspect(output, stego.ExtractOptions{})
+		if err != nil || info.Header == nil {
+			t.Fatalf("%s: Inspect failed: %v", name, err)
+		}
+
+		// The GDP file is the compressed input plus the overhead capacity assumed
+		size := int64(info.Header.HeaderSize) + int64(info.Header.CiphertextSize)
+		want := report.InputCompressedBytes + capacity.RawBits/8 - capacity.UsableBytes
+		if test.opts.Padding == stego.PadFill {
+			want = capacity.RawBits / 8
+		}
+		if size != want {
+			t.Fatalf("%s: GDP file of %d bytes, capacity assumed %d", name, size, want)
+		}
+	}
+}
+
+// **Test 36: Capacity counts error correction, sync blocks and the stealth mask**
+func TestCapacityFraming(t *testing.T) {
+	containerPath := filepath.Join(t.TempDir(), "container.wav")
+	writeTestWAV(t, containerPath, 1, 16, 16)
+	input := filepath.Join(t.TempDir(), "random.bin")
+	data := make([]byte, 16384)
+	rand.New(rand.NewSource(36)).Read(data)
+
+	kdf := stego.KDFParams{Algorithm: stego.KDFPBKDF2, Time: 1000}
+	for name, opts := range map[string]stego.EmbedOptions{
+		"fec":      {Password: testPassword, Encryption: true, KDF: kdf, FEC: 32},
+		"sync":     {Password: testPassword, Encryption: true, KDF: kdf, Sync: true},
+		"fec+sync": {Password: testPassword, Encryption: true, KDF: kdf, FEC: 16, Sync: true},
+		"stealth":  {Password: testPassword, Encryption: true, KDF: kdf, Stealth: true},
+	} {
+		fits := func(size int) bool {
+			if err := os.WriteFile(input, data[:size], 0644); err != nil {
+				t.Fatalf("Writing input failed: %v", err)
+			}
+			// The modification time goes into the archive, keep it from changing the compressed size
+			if err := os.Chtimes(input, time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+				t.Fatal(err)
+			}
+			report, err := stego.ContainerCapacity(containerPath, []string{input}, 0, opts)
+			if err != nil {
+				t.Fatalf("%s: ContainerCapacity failed: %v", name, err)
+			}
+			for _, capacity := range report.Capacities {
+				if capacity.Depth == 1 && capacity.Encrypted {
+					return *capacity.InputFits
+				}
+			}
+			t.Fatalf("%s: no capacity at depth 1", name)
+			return false
+		}
+
+		// The largest input the report says fits must embed, one more byte must not
+		low, high := 0, len(data)
+		for low < high {
+			mid := (low + high + 1) / 2
+			if fits(mid) {
+				low = mid
+			} else {
+				high = mid - 1
+			}
+		}
+		for _, size := range []int{low, low + 1} {
+			fits(size)
+			err := stego.Embed([]string{input}, filepath.Join(t.TempDir(), "output.wav"), containerPath, opts)
+			if size == low && err != nil {
+				t.Fatalf("%s: embedding the %d bytes capacity says fit failed: %v", name, size, err)
+			}
+			if size > low && !errors.Is(err, stego.ErrCapacity) {
+				t.Fatalf("%s: embedding %d bytes, one more than capacity says fit: got %v, want ErrCapacity", name, size, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package stego
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Hidden files are packed into a tar archive before compression, so file
+// names, permission bits, modification times and sizes travel inside the
+// encrypted payload rather than in the clear GDP header. Directories are
+// stored with their paths relative to the directory given on the command
+// line. Owner names and IDs are left out.
+
+// FileEntry is a file or directory recovered from a payload archive.
+type FileEntry struct {
+	Name    string      // Slash-separated relative path
+	Mode    os.FileMode // Permission bits, plus os.ModeDir for directories
+	ModTime time.Time
+	Size    int64
+	Data    []byte
+}
+
+// PackFiles packs files and directory trees with their metadata into a tar archive.
+func PackFiles(inputs []string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := WriteArchive(&buf, inputs); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteArchive streams files and directory trees with their metadata to w as a tar archive.
+func WriteArchive(w io.Writer, inputs []string) error {
+	if len(inputs) == 0 {
+		return errors.New("no input files")
+	}
+
+	tw := tar.NewWriter(w)
+	seen := make(map[string]bool)
+
+	for _, input := range inputs {
+		abs, err := filepath.Abs(input)
+		if err != nil {
+			return err
+		}
+		root := filepath.Base(abs)
+		if root == string(filepath.Separator) || root == "." {
+			return fmt.Errorf("cannot pack %s", input)
+		}
+
+		err = filepath.WalkDir(abs, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(filepath.Dir(abs), file)
+			if err != nil {
+				return err
+			}
+			name := filepath.ToSlash(rel)
+			if seen[name] {
+				return fmt.Errorf("%s is given more than once", name)
+			}
+			seen[name] = true
+			return packEntry(tw, file, name, d)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to pack %s: %w", input, err)
+		}
+	}
+
+	return tw.Close()
+}
+
+// packEntry writes one file or directory to the archive.
+func packEntry(tw *tar.Writer, file, name string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+
+	header := &tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		ModTime: info.ModTime(),
+	}
+	switch {
+	case info.IsDir():
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		return tw.WriteHeader(header)
+	case info.Mode().IsRegular():
+		header.Typeflag = tar.TypeReg
+	default:
+		return fmt.Errorf("%s is not a regular file or directory", file)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header.Size = info.Size()
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// UnpackArchive reads every file and directory held in a payload archive.
+func UnpackArchive(archive []byte) ([]FileEntry, error) {
+	var entries []FileEntry
+	tr := tar.NewReader(bytes.NewReader(archive))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid payload archive: %w", err)
+		}
+
+		entry := FileEntry{
+			Name:    path.Clean(header.Name),
+			Mode:    os.FileMode(header.Mode).Perm(),
+			ModTime: header.ModTime,
+			Size:    header.Size,
+		}
+		if !filepath.IsLocal(filepath.FromSlash(entry.Name)) {
+			return nil, fmt.Errorf("invalid payload archive: unsafe path %q", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			entry.Mode |= os.ModeDir
+		case tar.TypeReg:
+			entry.Data, err = io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid payload archive: %w", err)
+			}
+		default:
+			return nil, fmt.Errorf("invalid payload archive: %s is not a regular file or directory", header.Name)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		return nil, errors.New("payload archive is empty")
+	}
+
+	return entries, nil
+}
+
+// FormatFileEntry returns a listing line for an archive entry.
+func FormatFileEntry(entry FileEntry) string {
+	name := entry.Name
+	if entry.Mode.IsDir() {
+		name += "/"
+	}
+	return fmt.Sprintf("%s %10d %s %s", entry.Mode, entry.Size, entry.ModTime.Local().Format("2006-01-02 15:04"), name)
+}
+
+// WriteFileEntry writes a single extracted file and returns the path it was written to.
+// When output is an existing directory the file keeps its original name inside it.
+// With preserve set, the original permission bits and modification time are restored.
+func WriteFileEntry(entry *FileEntry, output string, preserve bool) (string, error) {
+	target := output
+	if info, err := os.Stat(output); err == nil && info.IsDir() {
+		target = filepath.Join(output, filepath.FromSlash(entry.Name))
+	}
+
+	if err := os.WriteFile(target, entry.Data, 0644); err != nil {
+		return "", err
+	}
+
+	if preserve {
+		if err := restoreMetadata(target, entry); err != nil {
+			return "", err
+		}
+	}
+
+	return target, nil
+}
+
+// WriteFileTree recreates the archive entries under the output directory, creating it if needed.
+func WriteFileTree(entries []FileEntry, output string, preserve bool) error {
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return err
+	}
+
+	for i := range entries {
+		target := filepath.Join(output, filepath.FromSlash(entries[i].Name))
+		if entries[i].Mode.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, entries[i].Data, 0644); err != nil {
+			return err
+		}
+	}
+
+	if !preserve {
+		return nil
+	}
+
+	// Restore in reverse so a directory's mtime is set after its contents are written
+	for i := len(entries) - 1; i >= 0; i-- {
+		target := filepath.Join(output, filepath.FromSlash(entries[i].Name))
+		if err := restoreMetadata(target, &entries[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreMetadata applies an entry's permission bits and modification time.
+func restoreMetadata(target string, entry *FileEntry) error {
+	if err := os.Chmod(target, entry.Mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(target, time.Now(), entry.ModTime)
+}
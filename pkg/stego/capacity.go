@@ -0,0 +1,263 @@
+package stego
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ulikunitz/xz"
+)
+
+// AES-GCM adds a nonce to the header and an authentication tag to the ciphertext.
+const (
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
+// Capacity is the room for a payload in a container at one depth.
+type Capacity struct {
+	Depth     int  `json:"depth"`
+	Encrypted bool `json:"encrypted"`
+
+	// RawBits is the number of bits available for the GDP file.
+	RawBits int64 `json:"raw_bits"`
+
+	// UsableBytes is the largest compressed payload that fits once the GDP
+	// header with the fields the options add, the padding and, when
+	// encrypted, the authentication tag are taken off, and the GDP file is
+	// framed with the error correction, sync blocks or stealth mask asked for.
+	UsableBytes int64 `json:"usable_bytes"`
+
+	// InputFits and InputPercent estimate whether, and how much of, the
+	// input fits. They are nil when no input files were given.
+	InputFits    *bool    `json:"input_fits,omitempty"`
+	InputPercent *float64 `json:"input_percent,omitempty"`
+}
+
+// CapacityReport describes how much a container can hold.
+type CapacityReport struct {
+	Container   string `json:"container"`
+	AudioFormat uint16 `json:"audio_format"`
+	BitDepth    uint16 `json:"bit_depth"`
+	Channels    uint16 `json:"channels"`
+	SampleRate  uint32 `json:"sample_rate"`
+	Samples     int64  `json:"samples"`
+	Cipher      string `json:"cipher"` // Cipher the encrypted capacities are worked out for
+
+	// InputBytes and InputCompressedBytes are only set when input files were given.
+	InputBytes           int64 `json:"input_bytes,omitempty"`
+	InputCompressedBytes int64 `json:"input_compressed_bytes,omitempty"`
+
+	Capacities []Capacity `json:"capacities"`
+}
+
+// ContainerCapacity reports the capacity of a container at every depth, with
+// and without encryption, for a payload embedded with opts. threshold is the
+// number of key shares EmbedShares would need, 0 when the key is not shared.
+// When inputFiles are given they are packed and XZ compressed as Embed would,
+// to estimate how much of them fits.
+func ContainerCapacity(container string, inputFiles []string, threshold int, opts EmbedOptions) (*CapacityReport, error) {
+	file, err := os.Open(container)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Only the header is read, the sample count follows from the data size
+	stream, err := readWAVHeader(file, 0)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := fileSamples(file, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := stream.metadata
+	report := &CapacityReport{
+		Container:   container,
+		AudioFormat: metadata.AudioFormat,
+		BitDepth:    metadata.BitDepth,
+		Channels:    metadata.NumChans,
+		SampleRate:  metadata.SampleRate,
+		Samples:     samples,
+		Cipher:      CipherName(opts.Cipher),
+	}
+
+	if len(inputFiles) > 0 {
+		report.InputBytes, report.InputCompressedBytes, err = compressedSize(inputFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for depth := MinDepth; depth <= MaxDepth; depth++ {
+		for _, encrypted := range []bool{true, false} {
+			rawBits := max(samples-layoutSize, 0) * int64(depth)
+			if opts.Stealth {
+				rawBits = samples * int64(depth)
+			}
+			capacity := Capacity{
+				Depth:       depth,
+				Encrypted:   encrypted,
+				RawBits:     rawBits,
+				UsableBytes: usableBytes(frameRoom(samples, depth, opts), encrypted, threshold, opts),
+			}
+			if report.InputCompressedBytes > 0 {
+				fits := report.InputCompressedBytes <= capacity.UsableBytes
+				percent := min(100, 100*float64(capacity.UsableBytes)/float64(report.InputCompressedBytes))
+				capacity.InputFits, capacity.InputPercent = &fits, &percent
+			}
+			report.Capacities = append(report.Capacities, capacity)
+		}
+	}
+
+	return report, nil
+}
+
+// fileSamples returns the number of samples in a container file, counting
+// them from the file size when the data chunk does not record its length.
+func fileSamples(file *os.File, stream *wavStream) (int64, error) {
+	samples := int64(stream.samples())
+	if samples < 0 {
+		info, err := file.Stat()
+		if err != nil {
+			return 0, err
+		}
+		dataSize := info.Size() - int64(len(stream.header))
+		samples = (dataSize - dataSize%int64(stream.metadata.BlockAlign)) / int64(stream.sampleSize)
+	}
+	return samples, nil
+}
+
+// containerSamples returns the number of samples in a container file from
+// its header.
+func containerSamples(container string) (int64, error) {
+	file, err := os.Open(container)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	stream, err := readWAVHeader(file, 0)
+	if err != nil {
+		return 0, err
+	}
+	return fileSamples(file, stream)
+}
+
+// frameRoom returns the bytes a framed GDP file may take in a container with
+// the given number of samples: all of them after the layout byte, or after
+// the salt of the mask in stealth mode.
+func frameRoom(samples int64, depth int, opts EmbedOptions) int64 {
+	if opts.Stealth {
+		return max(samples*int64(depth)/8-hiddenSaltSize, 0)
+	}
+	return max(samples-layoutSize, 0) * int64(depth) / 8
+}
+
+// payloadOverhead returns the size of the GDP header written by Embed with
+// opts, and the bytes it adds to the compressed payload: the length prefix of
+// a padded one, a sealed signature and the authentication tag. Recipients and
+// key shares only apply to encrypted payloads.
+func payloadOverhead(encrypted bool, threshold int, opts EmbedOptions) (int, int) {
+	gdp := GDPFile{Encryption: encrypted, Compression: CompressionXZ, Content: ContentTar}
+	overhead := 0
+	if encrypted {
+		// The KDF field is left out when recipients or shares replace the password
+		if opts.Password != "" || (len(opts.Recipients) == 0 && threshold == 0) {
+			gdp.KDF = DefaultKDFParams()
+			gdp.KDF.Salt = make([]byte, SaltSize)
+		}
+		gdp.Cipher = opts.Cipher
+		var nonceSize int
+		nonceSize, overhead = cipherSizes(opts.Cipher)
+		gdp.Nonce = make([]byte, nonceSize)
+		for range opts.Recipients {
+			gdp.Extensions = append(gdp.Extensions, GDPExtension{Type: ExtRecipient, Value: make([]byte, recipientStanzaSize)})
+		}
+	}
+	if opts.Checksum != ChecksumNone {
+		if checksum, err := newChecksum(opts.Checksum, nil); err == nil {
+			gdp.Extensions = append(gdp.Extensions, GDPExtension{Type: ExtChecksum, Value: make([]byte, 1+checksum.Size())})
+		}
+	}
+	if opts.Padding != 0 {
+		gdp.Extensions = append(gdp.Extensions, GDPExtension{Type: ExtPadding})
+		overhead += padLengthSize
+	}
+	if opts.SignKey != nil && encrypted {
+		gdp.Extensions = append(gdp.Extensions, GDPExtension{Type: ExtSealedSig})
+		overhead += signatureFieldSize
+	} else if opts.SignKey != nil {
+		gdp.Extensions = append(gdp.Extensions, GDPExtension{Type: ExtSignature, Value: make([]byte, signatureFieldSize)})
+	}
+	if encrypted && threshold != 0 {
+		gdp.Extensions = append(gdp.Extensions, GDPExtension{Type: ExtShare, Value: make([]byte, shareHeaderSize+keySize)})
+	}
+	// Only oversized nonces and extensions fail, which these fields are not
+	header, _ := MakeGDPFile(gdp)
+	return len(header), overhead
+}
+
+// usableBytes returns the largest compressed payload whose GDP file, framed
+// as opts asks, fits in the given room once the header and padding are taken
+// off.
+func usableBytes(room int64, encrypted bool, threshold int, opts EmbedOptions) int64 {
+	headerSize, overhead := payloadOverhead(encrypted, threshold, opts)
+	frame := int64(frameCapacity(int(room), headerSize, opts))
+	room = frame - int64(headerSize+overhead)
+	if opts.Padding > 0 {
+		// The length prefix is in the overhead, but the padded size is a multiple of the bucket
+		room += padLengthSize
+		room = room/int64(opts.Padding)*int64(opts.Padding) - padLengthSize
+	}
+	return max(room, 0)
+}
+
+// compressedSize packs and compresses the input files as Embed does and
+// returns the size of the files and of the compressed archive.
+func compressedSize(inputFiles []string) (int64, int64, error) {
+	var compressed countingWriter
+	xzWriter, err := xz.NewWriter(&compressed)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to create XZ writer: %w", err)
+	}
+	if err := WriteArchive(xzWriter, inputFiles); err != nil {
+		return 0, 0, err
+	}
+	if err := xzWriter.Close(); err != nil {
+		return 0, 0, fmt.Errorf("failed to close XZ writer: %w", err)
+	}
+
+	// The archive adds headers and padding, count only the files themselves
+	var files int64
+	for _, input := range inputFiles {
+		err := filepath.WalkDir(input, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err == nil && info.Mode().IsRegular() {
+				files += info.Size()
+			}
+			return err
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return files, compressed.n, nil
+}
+
+// countingWriter counts and discards what is written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,141 @@
+package stego
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrChecksum means the extracted plaintext does not match the digest stored
+// in the GDP header, so the carrier was damaged or modified.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// Checksum algorithms recorded in the ExtChecksum field.
+const (
+	ChecksumNone    uint8 = 0
+	ChecksumSHA256  uint8 = 1
+	ChecksumBLAKE2b uint8 = 2 // BLAKE2b-256
+)
+
+// checksumNames maps the names accepted on the command line to checksum identifiers.
+var checksumNames = map[string]uint8{
+	"none":    ChecksumNone,
+	"sha256":  ChecksumSHA256,
+	"blake2b": ChecksumBLAKE2b,
+}
+
+// ParseChecksum returns the checksum algorithm with the given name.
+func ParseChecksum(name string) (uint8, error) {
+	algorithm, ok := checksumNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown checksum %q: must be sha256, blake2b or none", name)
+	}
+	return algorithm, nil
+}
+
+// ChecksumName returns a readable name for a checksum identifier.
+func ChecksumName(algorithm uint8) string {
+	switch algorithm {
+	case ChecksumNone:
+		return "none"
+	case ChecksumSHA256:
+		return "SHA-256"
+	case ChecksumBLAKE2b:
+		return "BLAKE2b-256"
+	default:
+		return fmt.Sprintf("unknown (%d)", algorithm)
+	}
+}
+
+// newChecksum returns the hash for a checksum algorithm. With a key, the hash
+// is keyed (HMAC-SHA256 or keyed BLAKE2b) so the digest of an encrypted
+// payload says nothing about its plaintext to someone without the password.
+func newChecksum(algorithm uint8, key []byte) (hash.Hash, error) {
+	if key != nil {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("GoDeep checksum"))
+		key = mac.Sum(nil)
+	}
+
+	switch algorithm {
+	case ChecksumSHA256:
+		if key != nil {
+			return hmac.New(sha256.New, key), nil
+		}
+		return sha256.New(), nil
+	case ChecksumBLAKE2b:
+		return blake2b.New256(key)
+	default:
+		return nil, fmt.Errorf("%w: unknown checksum algorithm %d", ErrUnsupportedFormat, algorithm)
+	}
+}
+
+// checkChecksum compares the plaintext with the digest in a Checksum field value.
+func checkChecksum(value []byte, plaintext []byte, key []byte) error {
+	if len(value) < 1 {
+		return errors.New("invalid GDP file: checksum field too short")
+	}
+	h, err := newChecksum(value[0], key)
+	if err != nil {
+		return err
+	}
+	h.Write(plaintext)
+	if subtle.ConstantTimeCompare(h.Sum(nil), value[1:]) != 1 {
+		return fmt.Errorf("%w: the %s digest of the payload does not match", ErrChecksum, ChecksumName(value[0]))
+	}
+	return nil
+}
+
+// Verification reports what Verify checked.
+type Verification struct {
+	Checksum  string `json:"checksum"`  // Algorithm of the stored digest, "none" when there is none
+	Keyed     bool   `json:"keyed"`     // The digest is keyed with the password
+	Encrypted bool   `json:"encrypted"` // The cipher authenticated the payload as well
+	Cipher    string `json:"cipher"`    // Cipher of an encrypted payload
+	Size      int    `json:"size"`      // Plaintext bytes checked
+	Corrected int    `json:"corrected"` // Damaged bytes repaired by error correction first
+
+	// Signer is the key whose signature was verified, empty when the payload is unsigned.
+	Signer string `json:"signer,omitempty"`
+}
+
+// Verify extracts the payload hidden in a container and checks it against the
+// digest stored in its header, without writing anything. A mismatch returns
+// ErrChecksum. A payload with no digest and no encryption cannot be checked:
+// Verify then succeeds with Checksum set to "none" and Encrypted unset.
+func Verify(container string, opts ExtractOptions) (*Verification, error) {
+	return VerifySet([]string{container}, opts)
+}
+
+// VerifySet checks a payload split across several containers as Verify does.
+func VerifySet(containers []string, opts ExtractOptions) (*Verification, error) {
+	payload, err := openSet(containers, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	gdp := payload.Header
+	verification := &Verification{
+		Checksum:  ChecksumName(ChecksumNone),
+		Encrypted: gdp.Encryption,
+		Size:      len(payload.Raw),
+		Corrected: payload.Corrected,
+	}
+	if gdp.Encryption {
+		verification.Cipher = CipherName(gdp.Cipher)
+	}
+	if payload.Signer != nil {
+		verification.Signer = payload.Signer.String()
+	}
+	if value, ok := gdp.Extension(ExtChecksum); ok {
+		verification.Checksum = ChecksumName(value[0])
+		verification.Keyed = gdp.Encryption
+	}
+	return verification, nil
+}
@@ -0,0 +1,242 @@
+package stego
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// CompressAndEncrypt compresses the input data and then encrypts it with AES-GCM
+func CompressAndEncrypt(plaintext, key []byte) ([]byte, []byte, error) {
+	// Compress the data using XZ
+	compressed, err := CompressXZ(plaintext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("compression failed: %w", err)
+	}
+
+	// Encrypt the compressed data using AES-GCM
+	ciphertext, nonce, err := EncryptAESGCM(compressed, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encryption failed: %w", err)
+	}
+
+	return ciphertext, nonce, nil
+}
+
+// DecryptAndDecompress decrypts the data with the cipher recorded in its GDP
+// header and decompresses it using XZ
+func DecryptAndDecompress(ciphertext, key, nonce []byte, algorithm uint8) ([]byte, error) {
+	// Decrypt the data using the header's cipher
+	decrypted, err := Decrypt(ciphertext, nonce, key, algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+
+	// Decompress the decrypted data
+	decompressed, err := DecompressXZ(decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("decompression failed: %w", err)
+	}
+
+	return decompressed, nil
+}
+
+func CompressXZ(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := xz.NewWriter(&buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create XZ writer: %w", err)
+	}
+
+	_, err = writer.Write(data)
+	if err != nil {
+		writer.Close() // Ensure writer is closed before returning
+		return nil, fmt.Errorf("failed to write to XZ writer: %w", err)
+	}
+
+	err = writer.Close() // Explicitly check Close() error
+	if err != nil {
+		return nil, fmt.Errorf("failed to close XZ writer: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decompress reverses the compression recorded in a GDP header.
+func Decompress(data []byte, algorithm uint8) ([]byte, error) {
+	switch algorithm {
+	case CompressionNone:
+		return data, nil
+	case CompressionXZ:
+		return DecompressXZ(data)
+	default:
+		return nil, fmt.Errorf("%w: compression algorithm %d", ErrUnsupportedFormat, algorithm)
+	}
+}
+
+// DecompressXZ decompresses XZ data
+func DecompressXZ(compressedData []byte) ([]byte, error) {
+	reader, err := xz.NewReader(bytes.NewReader(compressedData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create XZ reader: %w", err)
+	}
+
+	return io.ReadAll(reader)
+}
+
+// EncryptAESGCM encrypts data using AES-GCM
+func EncryptAESGCM(plaintext, key []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create GCM cipher: %w", err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+	return ciphertext, nonce, nil
+}
+
+// DecryptAESGCM decrypts data using AES-GCM
+func DecryptAESGCM(ciphertext, nonce, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM cipher: %w", err)
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, fmt.Errorf("%w: bad AES-GCM nonce size", ErrAuthFailed)
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrong password or corrupted payload", ErrAuthFailed)
+	}
+
+	return plaintext, nil
+}
+
+// cipherNames maps the names accepted on the command line to cipher identifiers.
+var cipherNames = map[string]uint8{
+	"aes-gcm":   CipherAESGCM,
+	"aes":       CipherAESGCM,
+	"xchacha20": CipherXChaCha20,
+}
+
+// ParseCipher returns the cipher with the given name.
+func ParseCipher(name string) (uint8, error) {
+	algorithm, ok := cipherNames[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown cipher %q: must be aes-gcm or xchacha20", name)
+	}
+	return algorithm, nil
+}
+
+// CipherName returns a readable name for a cipher identifier.
+func CipherName(algorithm uint8) string {
+	switch algorithm {
+	case CipherAESGCM:
+		return "AES-GCM"
+	case CipherXChaCha20:
+		return "XChaCha20-Poly1305"
+	default:
+		return fmt.Sprintf("unknown (%d)", algorithm)
+	}
+}
+
+// cipherSizes returns the nonce size and the authentication tag size of a cipher.
+func cipherSizes(algorithm uint8) (int, int) {
+	if algorithm == CipherXChaCha20 {
+		return chacha20poly1305.NonceSizeX, chacha20poly1305.Overhead
+	}
+	return gcmNonceSize, gcmTagSize
+}
+
+// Encrypt encrypts data with the given cipher and a random nonce.
+func Encrypt(plaintext, key []byte, algorithm uint8) ([]byte, []byte, error) {
+	switch algorithm {
+	case CipherAESGCM:
+		return EncryptAESGCM(plaintext, key)
+	case CipherXChaCha20:
+		return EncryptXChaCha20(plaintext, key)
+	default:
+		return nil, nil, fmt.Errorf("%w: cipher %d", ErrUnsupportedFormat, algorithm)
+	}
+}
+
+// Decrypt decrypts data with the cipher recorded in a GDP header.
+func Decrypt(ciphertext, nonce, key []byte, algorithm uint8) ([]byte, error) {
+	switch algorithm {
+	case CipherAESGCM:
+		return DecryptAESGCM(ciphertext, nonce, key)
+	case CipherXChaCha20:
+		return DecryptXChaCha20(ciphertext, nonce, key)
+	default:
+		return nil, fmt.Errorf("%w: cipher %d", ErrUnsupportedFormat, algorithm)
+	}
+}
+
+// EncryptXChaCha20 encrypts data using XChaCha20-Poly1305, whose 24-byte
+// nonce is safe to pick at random
+func EncryptXChaCha20(plaintext, key []byte) ([]byte, []byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create XChaCha20-Poly1305 cipher: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	ciphertext := aead.Seal(nil, nonce, plaintext, nil)
+	return ciphertext, nonce, nil
+}
+
+// DecryptXChaCha20 decrypts data using XChaCha20-Poly1305
+func DecryptXChaCha20(ciphertext, nonce, key []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create XChaCha20-Poly1305 cipher: %w", err)
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%w: bad XChaCha20-Poly1305 nonce size", ErrAuthFailed)
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: wrong password or corrupted payload", ErrAuthFailed)
+	}
+
+	return plaintext, nil
+}
+
+// DerivePlacementSeed derives the seed for scattered sample placement from a key,
+// kept separate from the encryption key so neither reveals the other.
+func DerivePlacementSeed(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("GoDeep placement"))
+	return mac.Sum(nil)
+}
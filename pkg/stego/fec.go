@@ -0,0 +1,127 @@
+package stego
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Error correction
+// ---------------------------------------------------------
+// FEC header : 21 bytes, an RS(21,5) codeword holding the parity
+//              bytes per block (uint8) and the GDP file length (uint32)
+// Blocks     : the GDP file cut into blocks of 255-parity bytes, the last
+//              one zero-padded, each followed by its parity bytes
+// ---------------------------------------------------------
+//
+// The blocks are interleaved byte by byte, so a burst of damaged samples is
+// spread over many blocks instead of overwhelming one. With FEC the layout
+// byte has layoutFEC set and the LSB stream holds this frame instead of the
+// bare GDP file.
+
+const (
+	// MaxFEC is the largest number of parity bytes per 255-byte block.
+	MaxFEC = 128
+
+	fecBlockSize    = 255
+	fecHeaderData   = 5
+	fecHeaderParity = 16
+	fecHeaderSize   = fecHeaderData + fecHeaderParity
+)
+
+// ValidateFEC checks that parity is a supported number of parity bytes per block.
+func ValidateFEC(parity int) error {
+	if parity < 2 || parity > MaxFEC {
+		return fmt.Errorf("invalid error correction level %d: must be between 2 and %d parity bytes", parity, MaxFEC)
+	}
+	return nil
+}
+
+// fecBlocks returns the number of blocks needed for size bytes.
+func fecBlocks(size int, parity int) int {
+	data := fecBlockSize - parity
+	return (size + data - 1) / data
+}
+
+// FECSize returns the number of bytes a GDP file of the given size takes with
+// error correction at the given parity.
+func FECSize(size int, parity int) int {
+	return fecHeaderSize + fecBlocks(size, parity)*fecBlockSize
+}
+
+// encodeFEC wraps a GDP file in an error-correcting frame.
+func encodeFEC(gdpFile []byte, parity int) []byte {
+	header := make([]byte, fecHeaderData)
+	header[0] = byte(parity)
+	binary.LittleEndian.PutUint32(header[1:], uint32(len(gdpFile)))
+
+	blocks := fecBlocks(len(gdpFile), parity)
+	frame := make([]byte, FECSize(len(gdpFile), parity))
+	copy(frame, rsEncode(header, fecHeaderParity))
+
+	dataSize := fecBlockSize - parity
+	block := make([]byte, dataSize)
+	for j := 0; j < blocks; j++ {
+		clear(block)
+		copy(block, gdpFile[min(j*dataSize, len(gdpFile)):])
+		for i, b := range rsEncode(block, parity) {
+			frame[fecHeaderSize+i*blocks+j] = b
+		}
+	}
+	return frame
+}
+
+// fecHeader corrects and reads the FEC header at the start of data.
+func fecHeader(data []byte) (int, int, int, error) {
+	if len(data) < fecHeaderSize {
+		return 0, 0, 0, errGDPTruncated
+	}
+	header := append([]byte(nil), data[:fecHeaderSize]...)
+	corrected, err := rsDecode(header, fecHeaderParity)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: error correction header damaged beyond repair", ErrBadMagic)
+	}
+	parity := int(header[0])
+	if err := ValidateFEC(parity); err != nil {
+		return 0, 0, 0, fmt.Errorf("%w: %v", ErrBadMagic, err)
+	}
+	return parity, int(binary.LittleEndian.Uint32(header[1:5])), corrected, nil
+}
+
+// fecFrameSize returns the size of the frame starting at data.
+func fecFrameSize(data []byte) (int, error) {
+	parity, size, _, err := fecHeader(data)
+	if err != nil {
+		return 0, err
+	}
+	return FECSize(size, parity), nil
+}
+
+// decodeFEC corrects a frame and returns the GDP file it holds, its parity
+// and the number of bytes that were corrected.
+func decodeFEC(frame []byte) ([]byte, int, int, error) {
+	parity, size, corrected, err := fecHeader(frame)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	blocks := fecBlocks(size, parity)
+	if len(frame) < FECSize(size, parity) {
+		return nil, 0, 0, errNotEnoughPCM
+	}
+
+	dataSize := fecBlockSize - parity
+	gdpFile := make([]byte, 0, blocks*dataSize)
+	codeword := make([]byte, fecBlockSize)
+	for j := 0; j < blocks; j++ {
+		for i := range codeword {
+			codeword[i] = frame[fecHeaderSize+i*blocks+j]
+		}
+		n, err := rsDecode(codeword, parity)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("block %d of %d: %w", j+1, blocks, err)
+		}
+		corrected += n
+		gdpFile = append(gdpFile, codeword[:dataSize]...)
+	}
+
+	return gdpFile[:size], parity, corrected, nil
+}
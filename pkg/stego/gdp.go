@@ -0,0 +1,470 @@
+package stego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// GDP File Structure (version 3)
+// ---------------------------------------------------------
+// Magick bytes       : 3 (byte[3]) ("GDP" -> "\x47\x44\x50")
+// Version            : 1 byte (uint8) (3)
+// Encryption         : 1 byte (bool)
+// Size of Nonce      : 1 byte (uint8)
+// Nonce              : 0-255 bytes (based on Size of Nonce)
+// Size of Extensions : 4 bytes (uint32)
+// Extensions         : TLV fields (based on Size of Extensions)
+// Size of Ciphertext : 8 bytes (uint64)
+// Ciphertext         : 0-18,446,744,073,709,551,615 bytes
+// ---------------------------------------------------------
+//
+// Extension Field (TLV)
+// ---------------------------------------------------------
+// Type               : 1 byte (uint8) (bit 7 set: critical)
+// Length             : 2 bytes (uint16)
+// Value              : 0-65,535 bytes (based on Length)
+// ---------------------------------------------------------
+//
+// Readers skip extension types they do not know, unless the type is marked
+// critical, in which case the file cannot be read correctly and is rejected.
+//
+// GDP File Structure (version 2)
+// ---------------------------------------------------------
+// Magick bytes       : 3 (byte[3]) ("GDP" -> "\x47\x44\x50")
+// Version            : 1 byte (uint8) (2)
+// Encryption         : 1 byte (bool)
+// KDF                : 1 byte (uint8)
+// KDF Time           : 4 bytes (uint32)
+// KDF Memory         : 4 bytes (uint32, KiB)
+// KDF Threads        : 1 byte (uint8)
+// Size of Salt       : 1 byte (uint8)
+// Salt               : 0-255 bytes (based on Size of Salt)
+// Size of Nonce      : 1 byte (uint8)
+// Nonce              : 0-255 bytes (based on Size of Nonce)
+// Size of Ciphertext : 8 bytes (uint64)
+// Ciphertext         : 0-18,446,744,073,709,551,615 bytes
+// ---------------------------------------------------------
+//
+// GDP File Structure (version 1)
+// ---------------------------------------------------------
+// Magick bytes       : 3 (byte[3]) ("GDP" -> "\x47\x44\x50")
+// Encryption         : 1 byte (bool)
+// Size of Nonce      : 1 byte (uint8)
+// Nonce              : 0-255 bytes (based on Size of Nonce)
+// Size of Ciphertext : 8 bytes (uint64)
+// Ciphertext         : 0-18,446,744,073,709,551,615 bytes
+// ---------------------------------------------------------
+//
+// Version 1 has no version field: its encryption flag (0 or 1) sits where the
+// version is now, and its key is derived with LegacyKDFParams. Versions 1 and
+// 2 are always XZ compressed and carry the raw file.
+
+const (
+	// GDPVersion is the version written by MakeGDPFile.
+	GDPVersion = 3
+
+	gdpMinSizeV1 = 13
+	gdpMinSizeV2 = 25
+	gdpMinSizeV3 = 18
+)
+
+// Extension field types. Types with bit 7 set are critical.
+const (
+	ExtKDF         uint8 = 0x81 // KDF (1), Time (4), Memory (4), Threads (1), Salt
+	ExtCompression uint8 = 0x82 // Compression algorithm (1)
+	ExtContent     uint8 = 0x86 // Payload content type (1)
+	ExtShare       uint8 = 0x87 // Set ID (8), Threshold (1), Count (1), Index (1), key share
+	ExtRecipient   uint8 = 0x88 // Ephemeral X25519 key (32), wrapped file key; one per recipient
+	ExtPadding     uint8 = 0x89 // Empty; the plaintext is length-prefixed and padded
+	ExtCipher      uint8 = 0x8A // Cipher algorithm (1), AES-GCM when absent
+	ExtSealedSig   uint8 = 0x8B // Empty; the plaintext starts with the Signature field
+	ExtFilename    uint8 = 0x03 // UTF-8 file name
+	ExtMIMEType    uint8 = 0x04 // MIME type of the hidden file
+	ExtChecksum    uint8 = 0x05 // Checksum algorithm (1), digest
+	ExtSignature   uint8 = 0x06 // Ed25519 public key (32), signature (64)
+
+	extCritical = 0x80
+)
+
+// Compression algorithms recorded in the ExtCompression field.
+const (
+	CompressionNone uint8 = 0
+	CompressionXZ   uint8 = 1
+)
+
+// Ciphers recorded in the ExtCipher field of encrypted files.
+const (
+	CipherAESGCM    uint8 = 0
+	CipherXChaCha20 uint8 = 1 // XChaCha20-Poly1305
+)
+
+// Payload content types recorded in the ExtContent field.
+const (
+	ContentRaw uint8 = 0 // The plaintext is the hidden file itself
+	ContentTar uint8 = 1 // The plaintext is a tar archive holding the file and its metadata
+)
+
+// errGDPTruncated reports that the input ends before the GDP header does.
+var errGDPTruncated = errors.New("invalid GDP file: too short")
+
+// GDPExtension is a raw extension field of a GDP header.
+type GDPExtension struct {
+	Type  uint8
+	Value []byte
+}
+
+// GDPFile is a parsed GDP file.
+type GDPFile struct {
+	Version     uint8
+	Encryption  bool
+	KDF         KDFParams // Key derivation for encrypted files
+	Cipher      uint8     // Cipher of encrypted files, CipherAESGCM when absent
+	Compression uint8
+	Content     uint8 // How the plaintext is packed, ContentRaw when absent
+	Nonce       []byte
+
+	// Extensions holds the extension fields that have no dedicated field above.
+	Extensions []GDPExtension
+
+	CiphertextSize uint64
+	Ciphertext     []byte // Nil when only the header was parsed
+	HeaderSize     int    // Length of everything in front of the ciphertext
+}
+
+// Extension returns the value of the first extension field of the given type.
+func (g *GDPFile) Extension(extType uint8) ([]byte, bool) {
+	for _, ext := range g.Extensions {
+		if ext.Type == extType {
+			return ext.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ExtensionValues returns the values of every extension field of the given type.
+func (g *GDPFile) ExtensionValues(extType uint8) [][]byte {
+	var values [][]byte
+	for _, ext := range g.Extensions {
+		if ext.Type == extType {
+			values = append(values, ext.Value)
+		}
+	}
+	return values
+}
+
+// SetExtension replaces or adds the extension field of the given type.
+func (g *GDPFile) SetExtension(extType uint8, value []byte) {
+	for i, ext := range g.Extensions {
+		if ext.Type == extType {
+			g.Extensions[i].Value = value
+			return
+		}
+	}
+	g.Extensions = append(g.Extensions, GDPExtension{Type: extType, Value: value})
+}
+
+// ParseGDPFile parses a GDP file of any supported version.
+// In dummy mode only the header is parsed and the ciphertext is left out.
+func ParseGDPFile(input []byte, dummy bool) (*GDPFile, error) {
+	if len(input) < gdpMinSizeV1 {
+		return nil, errGDPTruncated
+	}
+
+	// Check magic bytes
+	if !bytes.Equal(input[:3], []byte("GDP")) {
+		return nil, fmt.Errorf("%w: incorrect magic bytes %x", ErrBadMagic, input[:3])
+	}
+
+	var gdp *GDPFile
+	var err error
+	switch input[3] {
+	case 0, 1:
+		gdp, err = parseGDPHeaderV1(input)
+	case 2:
+		gdp, err = parseGDPHeaderV2(input)
+	case 3:
+		gdp, err = parseGDPHeaderV3(input)
+	default:
+		return nil, fmt.Errorf("%w: GDP version %d", ErrUnsupportedFormat, input[3])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A payload whose key is shared or encrypted to recipients needs no password
+	_, shared := gdp.Extension(ExtShare)
+	_, recipients := gdp.Extension(ExtRecipient)
+	if gdp.Encryption && gdp.KDF.Algorithm == KDFNone && !shared && !recipients {
+		return nil, errors.New("invalid GDP file: encrypted payload without a KDF")
+	}
+
+	if !dummy {
+		if gdp.CiphertextSize > uint64(len(input)-gdp.HeaderSize) {
+			return nil, errors.New("invalid GDP file: incomplete ciphertext")
+		}
+
+		// Read ciphertext
+		gdp.Ciphertext = input[gdp.HeaderSize : gdp.HeaderSize+int(gdp.CiphertextSize)]
+	}
+
+	return gdp, nil
+}
+
+// parseGDPHeaderV1 decodes a version 1 header, which has no version field.
+func parseGDPHeaderV1(input []byte) (*GDPFile, error) {
+	gdp := &GDPFile{Version: 1, Compression: CompressionXZ}
+
+	// Read encryption flag (1 byte)
+	gdp.Encryption = input[3] != 0
+	if gdp.Encryption {
+		gdp.KDF = LegacyKDFParams()
+	}
+
+	// Read nonce size (1 byte) and nonce (variable length)
+	nonceSize := int(input[4])
+	if len(input) < gdpMinSizeV1+nonceSize {
+		return nil, errGDPTruncated
+	}
+	gdp.Nonce = input[5 : 5+nonceSize]
+
+	// Read ciphertext size (8 bytes, uint64)
+	gdp.CiphertextSize = binary.LittleEndian.Uint64(input[5+nonceSize : 5+nonceSize+8])
+	gdp.HeaderSize = 5 + nonceSize + 8
+
+	return gdp, nil
+}
+
+// parseGDPHeaderV2 decodes a version 2 header.
+func parseGDPHeaderV2(input []byte) (*GDPFile, error) {
+	if len(input) < gdpMinSizeV2 {
+		return nil, errGDPTruncated
+	}
+
+	gdp := &GDPFile{Version: 2, Compression: CompressionXZ}
+	gdp.Encryption = input[4] != 0
+
+	// Read KDF identifier and cost parameters
+	gdp.KDF.Algorithm = input[5]
+	gdp.KDF.Time = binary.LittleEndian.Uint32(input[6:10])
+	gdp.KDF.Memory = binary.LittleEndian.Uint32(input[10:14])
+	gdp.KDF.Threads = input[14]
+
+	// Read salt size (1 byte) and salt (variable length)
+	saltSize := int(input[15])
+	if len(input) < gdpMinSizeV2+saltSize {
+		return nil, errGDPTruncated
+	}
+	gdp.KDF.Salt = input[16 : 16+saltSize]
+
+	// Read nonce size (1 byte) and nonce (variable length)
+	offset := 16 + saltSize
+	nonceSize := int(input[offset])
+	if len(input) < gdpMinSizeV2+saltSize+nonceSize {
+		return nil, errGDPTruncated
+	}
+	gdp.Nonce = input[offset+1 : offset+1+nonceSize]
+
+	// Read ciphertext size (8 bytes, uint64)
+	offset += 1 + nonceSize
+	gdp.CiphertextSize = binary.LittleEndian.Uint64(input[offset : offset+8])
+	gdp.HeaderSize = offset + 8
+
+	return gdp, nil
+}
+
+// parseGDPHeaderV3 decodes a version 3 header and its extension fields.
+func parseGDPHeaderV3(input []byte) (*GDPFile, error) {
+	if len(input) < gdpMinSizeV3 {
+		return nil, errGDPTruncated
+	}
+
+	gdp := &GDPFile{Version: 3, Compression: CompressionXZ}
+	gdp.Encryption = input[4] != 0
+
+	// Read nonce size (1 byte) and nonce (variable length)
+	nonceSize := int(input[5])
+	if len(input) < gdpMinSizeV3+nonceSize {
+		return nil, errGDPTruncated
+	}
+	gdp.Nonce = input[6 : 6+nonceSize]
+
+	// Read extensions size (4 bytes, uint32) and extensions (variable length)
+	offset := 6 + nonceSize
+	extensionsSize := int(binary.LittleEndian.Uint32(input[offset : offset+4]))
+	offset += 4
+	if len(input)-offset-8 < extensionsSize {
+		return nil, errGDPTruncated
+	}
+	if err := gdp.parseExtensions(input[offset : offset+extensionsSize]); err != nil {
+		return nil, err
+	}
+
+	// Read ciphertext size (8 bytes, uint64)
+	offset += extensionsSize
+	gdp.CiphertextSize = binary.LittleEndian.Uint64(input[offset : offset+8])
+	gdp.HeaderSize = offset + 8
+
+	return gdp, nil
+}
+
+// parseExtensions decodes the TLV extension area into the dedicated fields and Extensions.
+func (g *GDPFile) parseExtensions(area []byte) error {
+	for len(area) > 0 {
+		if len(area) < 3 {
+			return errors.New("invalid GDP file: truncated extension field")
+		}
+		extType := area[0]
+		length := int(binary.LittleEndian.Uint16(area[1:3]))
+		if len(area) < 3+length {
+			return errors.New("invalid GDP file: truncated extension field")
+		}
+		value := area[3 : 3+length]
+		area = area[3+length:]
+
+		switch extType {
+		case ExtKDF:
+			if length < 10 {
+				return errors.New("invalid GDP file: KDF field too short")
+			}
+			g.KDF = KDFParams{
+				Algorithm: value[0],
+				Time:      binary.LittleEndian.Uint32(value[1:5]),
+				Memory:    binary.LittleEndian.Uint32(value[5:9]),
+				Threads:   value[9],
+				Salt:      value[10:],
+			}
+		case ExtCompression:
+			if length != 1 {
+				return errors.New("invalid GDP file: bad compression field")
+			}
+			g.Compression = value[0]
+		case ExtContent:
+			if length != 1 || value[0] > ContentTar {
+				return fmt.Errorf("%w: unknown GDP content type", ErrUnsupportedFormat)
+			}
+			g.Content = value[0]
+		case ExtCipher:
+			if length != 1 || value[0] > CipherXChaCha20 {
+				return fmt.Errorf("%w: unknown GDP cipher", ErrUnsupportedFormat)
+			}
+			g.Cipher = value[0]
+		case ExtFilename, ExtMIMEType, ExtChecksum, ExtSignature, ExtSealedSig, ExtShare, ExtRecipient, ExtPadding:
+			g.Extensions = append(g.Extensions, GDPExtension{Type: extType, Value: value})
+		default:
+			if extType&extCritical != 0 {
+				return fmt.Errorf("%w: unknown critical GDP extension %#x", ErrUnsupportedFormat, extType)
+			}
+			g.Extensions = append(g.Extensions, GDPExtension{Type: extType, Value: value})
+		}
+	}
+
+	return nil
+}
+
+// MakeGDPFile constructs a version 3 GDP file from the given fields and ciphertext.
+func MakeGDPFile(gdp GDPFile) ([]byte, error) {
+	if len(gdp.Nonce) > 255 {
+		return nil, errors.New("nonce size exceeds 255 bytes")
+	}
+
+	// Build the extension area, dedicated fields first
+	var extensions bytes.Buffer
+	if gdp.Encryption {
+		var kdf bytes.Buffer
+		kdf.WriteByte(gdp.KDF.Algorithm)
+		binary.Write(&kdf, binary.LittleEndian, gdp.KDF.Time)
+		binary.Write(&kdf, binary.LittleEndian, gdp.KDF.Memory)
+		kdf.WriteByte(gdp.KDF.Threads)
+		kdf.Write(gdp.KDF.Salt)
+		if err := writeExtension(&extensions, ExtKDF, kdf.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeExtension(&extensions, ExtCompression, []byte{gdp.Compression}); err != nil {
+		return nil, err
+	}
+	if gdp.Content != ContentRaw {
+		if err := writeExtension(&extensions, ExtContent, []byte{gdp.Content}); err != nil {
+			return nil, err
+		}
+	}
+	if gdp.Encryption && gdp.Cipher != CipherAESGCM {
+		if err := writeExtension(&extensions, ExtCipher, []byte{gdp.Cipher}); err != nil {
+			return nil, err
+		}
+	}
+	for _, ext := range gdp.Extensions {
+		if err := writeExtension(&extensions, ext.Type, ext.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+
+	// Write magic bytes "GDP" and version
+	buf.Write([]byte("GDP"))
+	buf.WriteByte(GDPVersion)
+
+	// Write encryption flag (1 byte)
+	if gdp.Encryption {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+
+	// Write nonce size (1 byte) and nonce data
+	buf.WriteByte(byte(len(gdp.Nonce)))
+	buf.Write(gdp.Nonce)
+
+	// Write extensions size (4 bytes, uint32 in little-endian) and extensions
+	binary.Write(&buf, binary.LittleEndian, uint32(extensions.Len()))
+	buf.Write(extensions.Bytes())
+
+	// Write ciphertext size (8 bytes, uint64 in little-endian)
+	ciphertextSize := uint64(len(gdp.Ciphertext))
+	binary.Write(&buf, binary.LittleEndian, ciphertextSize)
+
+	// Write ciphertext
+	buf.Write(gdp.Ciphertext)
+
+	return buf.Bytes(), nil
+}
+
+// writeExtension appends a TLV extension field.
+func writeExtension(buf *bytes.Buffer, extType uint8, value []byte) error {
+	if len(value) > 0xFFFF {
+		return fmt.Errorf("extension %#x exceeds 65535 bytes", extType)
+	}
+	buf.WriteByte(extType)
+	binary.Write(buf, binary.LittleEndian, uint16(len(value)))
+	buf.Write(value)
+	return nil
+}
+
+// ReadGDPFile reads a GDP file from disk and parses its contents.
+func ReadGDPFile(input string) (*GDPFile, error) {
+	file, err := os.ReadFile(input)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGDPFile(file, false)
+}
+
+// WriteGDPFile writes a GDP file to disk.
+func WriteGDPFile(filename string, gdp GDPFile) error {
+	file, err := MakeGDPFile(gdp)
+	if err != nil {
+		return err
+	}
+
+	// Write to file
+	err = os.WriteFile(filename, file, 0644)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package stego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// TestParseGDPVersions reads headers of every version.
+func TestParseGDPVersions(t *testing.T) {
+	nonce, ciphertext := []byte("0123456789ab"), []byte("ciphertext")
+
+	// Version 1 keeps its encryption flag where the version is now
+	var v1 bytes.Buffer
+	v1.WriteString("GDP")
+	v1.Write([]byte{1, byte(len(nonce))})
+	v1.Write(nonce)
+	binary.Write(&v1, binary.LittleEndian, uint64(len(ciphertext)))
+	v1.Write(ciphertext)
+	gdp, err := ParseGDPFile(v1.Bytes(), false)
+	if err != nil {
+		t.Fatalf("version 1: %v", err)
+	}
+	if gdp.Version != 1 || !gdp.Encryption || gdp.KDF.Algorithm != KDFPBKDF2 || string(gdp.KDF.Salt) != "GoDeepSalt" ||
+		!bytes.Equal(gdp.Nonce, nonce) || !bytes.Equal(gdp.Ciphertext, ciphertext) || gdp.HeaderSize != v1.Len()-len(ciphertext) {
+		t.Fatalf("version 1 fields: %+v", gdp)
+	}
+
+	// Version 2 has fixed KDF fields
+	var v2 bytes.Buffer
+	v2.WriteString("GDP")
+	v2.Write([]byte{2, 1, KDFArgon2id})
+	binary.Write(&v2, binary.LittleEndian, uint32(3))
+	binary.Write(&v2, binary.LittleEndian, uint32(65536))
+	v2.Write([]byte{4, 4})
+	v2.WriteString("salt")
+	v2.WriteByte(byte(len(nonce)))
+	v2.Write(nonce)
+	binary.Write(&v2, binary.LittleEndian, uint64(len(ciphertext)))
+	v2.Write(ciphertext)
+	gdp, err = ParseGDPFile(v2.Bytes(), false)
+	if err != nil {
+		t.Fatalf("version 2: %v", err)
+	}
+	kdf := KDFParams{Algorithm: KDFArgon2id, Time: 3, Memory: 65536, Threads: 4}
+	if gdp.Version != 2 || !gdp.Encryption || gdp.KDF.Algorithm != kdf.Algorithm || gdp.KDF.Time != kdf.Time ||
+		gdp.KDF.Memory != kdf.Memory || gdp.KDF.Threads != kdf.Threads || string(gdp.KDF.Salt) != "salt" ||
+		!bytes.Equal(gdp.Nonce, nonce) || !bytes.Equal(gdp.Ciphertext, ciphertext) {
+		t.Fatalf("version 2 fields: %+v", gdp)
+	}
+
+	// Version 3 moves everything optional into extension fields
+	kdf.Salt = []byte("salt")
+	v3, err := MakeGDPFile(GDPFile{
+		Encryption:  true,
+		KDF:         kdf,
+		Compression: CompressionXZ,
+		Content:     ContentTar,
+		Cipher:      CipherXChaCha20,
+		Nonce:       nonce,
+		Extensions:  []GDPExtension{{Type: ExtChecksum, Value: []byte{ChecksumSHA256}}, {Type: 0x7E, Value: []byte("unknown")}},
+		Ciphertext:  ciphertext,
+	})
+	if err != nil {
+		t.Fatalf("MakeGDPFile: %v", err)
+	}
+	gdp, err = ParseGDPFile(v3, false)
+	if err != nil {
+		t.Fatalf("version 3: %v", err)
+	}
+	if gdp.Version != GDPVersion || gdp.Content != ContentTar || gdp.Cipher != CipherXChaCha20 ||
+		gdp.KDF.Memory != kdf.Memory || string(gdp.KDF.Salt) != "salt" || !bytes.Equal(gdp.Ciphertext, ciphertext) {
+		t.Fatalf("version 3 fields: %+v", gdp)
+	}
+	if value, ok := gdp.Extension(0x7E); !ok || string(value) != "unknown" {
+		t.Fatalf("unknown non-critical extension was not kept: %+v", gdp.Extensions)
+	}
+
+	// In dummy mode a header without its ciphertext is enough
+	header, err := ParseGDPFile(v3[:gdp.HeaderSize], true)
+	if err != nil || header.CiphertextSize != uint64(len(ciphertext)) || header.Ciphertext != nil {
+		t.Fatalf("header only: %+v, %v", header, err)
+	}
+	if _, err := ParseGDPFile(v3[:gdp.HeaderSize-1], true); !errors.Is(err, errGDPTruncated) {
+		t.Fatalf("truncated header: got %v, want errGDPTruncated", err)
+	}
+	if _, err := ParseGDPFile(v3[:len(v3)-1], false); err == nil {
+		t.Fatal("incomplete ciphertext was accepted")
+	}
+}
+
+// TestParseGDPRejects refuses files this version cannot read correctly.
+func TestParseGDPRejects(t *testing.T) {
+	v3, err := MakeGDPFile(GDPFile{Compression: CompressionXZ, Extensions: []GDPExtension{{Type: 0xFE, Value: []byte("newer")}}})
+	if err != nil {
+		t.Fatalf("MakeGDPFile: %v", err)
+	}
+	if _, err := ParseGDPFile(v3, false); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Fatalf("unknown critical extension: got %v, want ErrUnsupportedFormat", err)
+	}
+
+	for name, test := range map[string]struct {
+		file []byte
+		want error
+	}{
+		"magic":        {append([]byte("GDX"), make([]byte, 20)...), ErrBadMagic},
+		"version":      {append([]byte("GDP\x09"), make([]byte, 20)...), ErrUnsupportedFormat},
+		"content type": {mustGDP(t, GDPExtension{Type: ExtContent, Value: []byte{9}}), ErrUnsupportedFormat},
+		"cipher":       {mustGDP(t, GDPExtension{Type: ExtCipher, Value: []byte{9}}), ErrUnsupportedFormat},
+	} {
+		if _, err := ParseGDPFile(test.file, false); !errors.Is(err, test.want) {
+			t.Fatalf("%s: got %v, want %v", name, err, test.want)
+		}
+	}
+
+	// An encrypted file must say how its key is found
+	v3, err = MakeGDPFile(GDPFile{Encryption: true, Compression: CompressionXZ})
+	if err != nil {
+		t.Fatalf("MakeGDPFile: %v", err)
+	}
+	if _, err := ParseGDPFile(v3, false); err == nil {
+		t.Fatal("encrypted file without a KDF was accepted")
+	}
+}
+
+// mustGDP builds an unencrypted version 3 file with the given extension field.
+func mustGDP(t *testing.T, extension GDPExtension) []byte {
+	var extensions bytes.Buffer
+	if err := writeExtension(&extensions, extension.Type, extension.Value); err != nil {
+		t.Fatal(err)
+	}
+	var file bytes.Buffer
+	file.WriteString("GDP")
+	file.Write([]byte{GDPVersion, 0, 0})
+	binary.Write(&file, binary.LittleEndian, uint32(extensions.Len()))
+	file.Write(extensions.Bytes())
+	binary.Write(&file, binary.LittleEndian, uint64(0))
+	return file.Bytes()
+}
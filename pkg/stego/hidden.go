@@ -0,0 +1,236 @@
+package stego
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Hidden payloads
+// ---------------------------------------------------------
+// Salt       : 16 bytes, random, also the CTR IV
+// Masked GDP : the whole GDP file XORed with AES-256-CTR
+// ---------------------------------------------------------
+//
+// A hidden payload is a second GDP file behind a decoy. The decoy is written
+// in order from the layout byte as usual, and the hidden frame is scattered
+// over the samples after it, at positions derived from its own password. The
+// mask key comes from that password too, so without it the magic, header and
+// length read as noise and nothing tells the frame apart from the audio's
+// own LSBs. The decoy's length is in its header, so extraction finds where
+// the hidden frame starts without the decoy's password. A password the decoy
+// rejects is tried on the hidden frame.
+//
+// The mask key is derived with Argon2id at its default cost and the frame's
+// salt. The KDF and its cost are not recorded, and EmbedOptions.KDF does not
+// change them: any field in the frame that a guessed password could check
+// before running the KDF would make guessing cheap. The positions are needed
+// before the salt can be read, so they come from DeriveKey's fixed salt. They
+// only choose the samples; telling whether a guess found the frame still
+// takes the Argon2id run.
+
+const hiddenSaltSize = SaltSize
+
+// hiddenFrame is a masked GDP file and the seed of the positions it is scattered over.
+type hiddenFrame struct {
+	data []byte
+	seed []byte
+}
+
+// hiddenKeys holds the keys of a masked frame derived from its password.
+type hiddenKeys struct {
+	seed     []byte // Placement seed, known before anything is read
+	password string
+	salt     []byte // Salt the mask key was last derived for
+	key      []byte
+}
+
+// newHiddenKeys derives the placement seed of a masked frame from its password.
+func newHiddenKeys(password string) *hiddenKeys {
+	mac := hmac.New(sha256.New, DeriveKey(password))
+	mac.Write([]byte("GoDeep hidden placement"))
+	return &hiddenKeys{seed: mac.Sum(nil), password: password}
+}
+
+// maskKey derives the mask key of a frame with the given salt. The frame is
+// read a little more at a time, so the key of the last salt is kept.
+func (k *hiddenKeys) maskKey(salt []byte) ([]byte, error) {
+	if k.key != nil && bytes.Equal(salt, k.salt) {
+		return k.key, nil
+	}
+	key, err := DeriveKeyWithParams(k.password, KDFParams{
+		Algorithm: KDFArgon2id,
+		Time:      DefaultArgon2Time,
+		Memory:    DefaultArgon2Memory,
+		Threads:   DefaultArgon2Threads,
+		Salt:      salt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("GoDeep hidden mask"))
+	k.salt, k.key = bytes.Clone(salt), mac.Sum(nil)
+	return k.key, nil
+}
+
+// maskFrame hides a frame behind a random salt and an AES-CTR keystream.
+func maskFrame(frame []byte, keys *hiddenKeys) ([]byte, error) {
+	masked := make([]byte, hiddenSaltSize+len(frame))
+	if _, err := rand.Read(masked[:hiddenSaltSize]); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key, err := keys.maskKey(masked[:hiddenSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCTR(block, masked[:hiddenSaltSize]).XORKeyStream(masked[hiddenSaltSize:], frame)
+	return masked, nil
+}
+
+// unmaskFrame removes the mask from the start of a masked frame, which may
+// be cut short.
+func unmaskFrame(masked []byte, keys *hiddenKeys) ([]byte, error) {
+	if len(masked) < hiddenSaltSize {
+		return nil, errGDPTruncated
+	}
+	key, err := keys.maskKey(masked[:hiddenSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	frame := make([]byte, len(masked)-hiddenSaltSize)
+	cipher.NewCTR(block, masked[:hiddenSaltSize]).XORKeyStream(frame, masked[hiddenSaltSize:])
+	return frame, nil
+}
+
+// maskedFrameSize returns the size of a masked frame whose unmasked size is
+// given by size. With the wrong password the header does not parse.
+func maskedFrameSize(keys *hiddenKeys, size frameSize) frameSize {
+	return func(data []byte) (int, error) {
+		frame, err := unmaskFrame(data, keys)
+		if err != nil {
+			return 0, err
+		}
+		total, err := size(frame)
+		if err != nil {
+			return 0, err
+		}
+		return hiddenSaltSize + total, nil
+	}
+}
+
+// EmbedHidden hides decoyFiles in a WAV container under the password in opts,
+// and hiddenFiles behind them under hiddenPassword. Extraction returns the
+// decoy or the hidden files depending on the password it is given, and
+// without hiddenPassword nothing shows that the hidden files are there.
+func EmbedHidden(decoyFiles []string, hiddenFiles []string, outputFile string, container string, hiddenPassword string, opts EmbedOptions) error {
+	if !opts.Encryption || opts.Password == "" {
+		return errors.New("the decoy of a hidden payload must be encrypted with a password")
+	}
+	if hiddenPassword == "" || hiddenPassword == opts.Password {
+		return errors.New("the hidden payload needs a password of its own")
+	}
+	if len(opts.Recipients) > 0 {
+		return errors.New("a hidden payload cannot be combined with recipients")
+	}
+
+	debugf(opts.Logf, "Reading input files for hiding process.")
+	decoy, err := sealPayload(ContentTar, func(w io.Writer) error {
+		return WriteArchive(w, decoyFiles)
+	}, opts)
+	if err != nil {
+		return err
+	}
+
+	debugf(opts.Logf, "Reading input files for the hidden payload.")
+	hiddenOpts := opts
+	hiddenOpts.Password = hiddenPassword
+	gdpFile, err := sealPayload(ContentTar, func(w io.Writer) error {
+		return WriteArchive(w, hiddenFiles)
+	}, hiddenOpts)
+	if err != nil {
+		return err
+	}
+	keys := newHiddenKeys(hiddenPassword)
+	masked, err := maskFrame(gdpFile, keys)
+	if err != nil {
+		return err
+	}
+
+	temp, err := embedToTemp(outputFile, container, decoy, 0, &hiddenFrame{data: masked, seed: keys.seed}, opts)
+	if temp != "" {
+		defer os.Remove(temp)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp, outputFile)
+}
+
+// gatherHidden reads a masked frame scattered from offset and unmasks the GDP
+// file in it, or only its header. It returns ErrBadMagic when the keys find
+// nothing.
+func gatherHidden(stream *wavStream, offset int, depth int, keys *hiddenKeys, headerOnly bool) ([]byte, error) {
+	masked, err := gatherScatteredFrame(stream, offset, depth, keys.seed, maskedFrameSize(keys, gdpFrameSize(headerOnly)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: no hidden payload opens with this password (%v)", ErrBadMagic, err)
+	}
+	return unmaskFrame(masked, keys)
+}
+
+// openHidden looks for a payload hidden behind the frame that ends at the
+// given location and opens it with the password in opts. The carrier is read
+// again from its start. It returns ErrBadMagic when the password finds nothing.
+func openHidden(carrier io.Reader, location gdpLocation, opts ExtractOptions) (*Payload, error) {
+	sampleSize := 0
+	if opts.LegacyLayout {
+		sampleSize = LegacySampleSize
+	}
+	stream, err := readWAVHeader(carrier, sampleSize)
+	if err != nil {
+		return nil, err
+	}
+
+	gdpFile, err := gatherHidden(stream, location.end, location.depth, newHiddenKeys(opts.Password), false)
+	if err != nil {
+		return nil, err
+	}
+	debugf(opts.Logf, "Found a hidden payload of %d bytes from sample %d.", len(gdpFile), location.end)
+
+	payload, err := openGDP([][]byte{gdpFile}, opts)
+	if err != nil {
+		return nil, err
+	}
+	payload.Hidden = true
+	return payload, nil
+}
+
+// tryHidden tries the password in opts on a payload hidden behind the frame
+// that rejected it with openErr, reading the carrier from its start. When
+// there is none, openErr is returned.
+func tryHidden(carrier io.Reader, location gdpLocation, opts ExtractOptions, openErr error) (*Payload, error) {
+	if !errors.Is(openErr, ErrAuthFailed) || opts.Password == "" || location.end == 0 {
+		return nil, openErr
+	}
+	payload, err := openHidden(carrier, location, opts)
+	if errors.Is(err, ErrBadMagic) {
+		debugf(opts.Logf, "%v", err)
+		return nil, openErr
+	}
+	return payload, err
+}
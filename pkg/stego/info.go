@@ -0,0 +1,293 @@
+package stego
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ContainerInfo describes a container and the GDP header hidden in it.
+type ContainerInfo struct {
+	Container   string   `json:"container"`
+	AudioFormat uint16   `json:"audio_format"`
+	BitDepth    uint16   `json:"bit_depth"`
+	Channels    uint16   `json:"channels"`
+	SampleRate  uint32   `json:"sample_rate"`
+	Samples     int64    `json:"samples"`
+	Chunks      []string `json:"chunks"` // RIFF chunk IDs in front of the samples, then "data"
+
+	// Present reports whether a GDP header was found. When it is not,
+	// Reason tells why.
+	Present bool   `json:"present"`
+	Reason  string `json:"reason,omitempty"`
+
+	Depth     int  `json:"depth,omitempty"`
+	Offset    int  `json:"offset"` // Sample carrying the first bit of the data
+	Scattered bool `json:"scattered"`
+	Legacy    bool `json:"legacy"`            // Written without a layout byte
+	Stealth   bool `json:"stealth,omitempty"` // Masked and found by trial decryption with the password
+
+	// FEC is the number of parity bytes per error correction block, and
+	// Corrected the damaged bytes it repaired to read the header.
+	FEC       int `json:"fec,omitempty"`
+	Corrected int `json:"corrected,omitempty"`
+
+	// Blocks is the number of sync blocks the data was written in, and
+	// Missing lists the zero-based numbers of those not found.
+	Blocks  int   `json:"blocks,omitempty"`
+	Missing []int `json:"missing,omitempty"`
+
+	// Piece describes the container's share of a payload split across
+	// several. Only the first piece carries the GDP header.
+	Piece *PieceInfo `json:"piece,omitempty"`
+
+	Header *HeaderInfo `json:"header,omitempty"`
+}
+
+// PieceInfo is the piece header of a container holding part of a split set.
+type PieceInfo struct {
+	SetID  string `json:"set_id"`
+	Index  int    `json:"index"` // Zero-based piece number
+	Count  int    `json:"count"`
+	Length int    `json:"length"` // Bytes of the GDP file in this piece
+	Total  uint64 `json:"total"`  // Bytes of the whole GDP file
+}
+
+// HeaderInfo is the readable part of a GDP header.
+type HeaderInfo struct {
+	Version        uint8      `json:"version"`
+	Encrypted      bool       `json:"encrypted"`
+	KDF            *KDFInfo   `json:"kdf,omitempty"`
+	Cipher         string     `json:"cipher,omitempty"`
+	Compression    string     `json:"compression"`
+	Content        string     `json:"content"`
+	Filename       string     `json:"filename,omitempty"`
+	MIMEType       string     `json:"mime_type,omitempty"`
+	Checksum       string     `json:"checksum,omitempty"`   // Algorithm of the plaintext digest
+	Share          *ShareInfo `json:"share,omitempty"`      // Key share held by this container
+	Recipients     int        `json:"recipients,omitempty"` // X25519 keys the file key is encrypted to
+	Signer         string     `json:"signer,omitempty"`     // Ed25519 key an unencrypted payload claims to be signed by, not checked
+	Signed         bool       `json:"signed,omitempty"`     // Signed; the signer of an encrypted payload is only known once it is opened
+	Padded         bool       `json:"padded,omitempty"`     // Padding hides the size of the payload
+	NonceSize      int        `json:"nonce_size"`
+	HeaderSize     int        `json:"header_size"`
+	CiphertextSize uint64     `json:"ciphertext_size"`
+	Extensions     []uint8    `json:"extensions,omitempty"` // Extension types without a field above
+}
+
+// ShareInfo is the share of a payload key held by one container of a set.
+type ShareInfo struct {
+	SetID     string `json:"set_id"`
+	Index     int    `json:"index"` // One-based share number
+	Count     int    `json:"count"`
+	Threshold int    `json:"threshold"` // Shares needed to recover the key
+}
+
+// KDFInfo is the key derivation recorded for an encrypted payload.
+type KDFInfo struct {
+	Algorithm string `json:"algorithm"`
+	Time      uint32 `json:"time"`
+	MemoryKiB uint32 `json:"memory_kib"`
+	Threads   uint8  `json:"threads"`
+	SaltSize  int    `json:"salt_size"`
+}
+
+// Inspect reads the GDP header hidden in a container without decrypting the
+// payload. The password is only needed to locate scattered data and to unmask
+// the header of a stealth container. A container holding no GoDeep data is
+// reported with Present unset rather than an error.
+func Inspect(container string, opts ExtractOptions) (*ContainerInfo, error) {
+	file, err := os.Open(container)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sampleSize := 0
+	if opts.LegacyLayout {
+		sampleSize = LegacySampleSize
+	}
+	stream, err := readWAVHeader(file, sampleSize)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := fileSamples(file, stream)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := stream.metadata
+	info := &ContainerInfo{
+		Container:   container,
+		AudioFormat: metadata.AudioFormat,
+		BitDepth:    metadata.BitDepth,
+		Channels:    metadata.NumChans,
+		SampleRate:  metadata.SampleRate,
+		Samples:     samples * int64(stream.sampleSize) / int64(metadata.SampleSize()),
+		Chunks:      chunkIDs(stream.header),
+	}
+
+	var placementSeed []byte
+	if opts.Password != "" {
+		placementSeed = DerivePlacementSeed(DeriveKey(opts.Password))
+	}
+	header, location, err := extractGDP(stream, opts.Depth, placementSeed, true, opts.Logf)
+	if errors.Is(err, ErrBadMagic) && opts.Password != "" {
+		// Without a layout byte the data may be in stealth mode, whose header the password unmasks
+		if header, depth, stealthErr := findStealth(file, 0, true, opts); stealthErr == nil {
+			gdp, err := ParseGDPFile(header, true)
+			if err != nil {
+				return nil, err
+			}
+			info.Present, info.Stealth, info.Depth = true, true, depth
+			info.Header = headerInfo(gdp)
+			return info, nil
+		}
+	}
+	info.Depth, info.Offset, info.Scattered = location.depth, location.offset, location.scattered
+	info.Legacy = location.offset == 0 && location.depth > 0 && !location.sync
+	info.FEC, info.Corrected = location.fec, location.corrected
+	info.Blocks, info.Missing = location.blocks, location.missing
+	switch {
+	case errors.Is(err, errScatteredNoPassword):
+		info.Reason = "scattered placement, a password is needed to locate the data"
+		return info, nil
+	case errors.Is(err, ErrBadMagic):
+		info.Reason = err.Error()
+		return info, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if location.split {
+		p, err := parsePiece(header)
+		if errors.Is(err, ErrBadMagic) {
+			info.Reason = err.Error()
+			return info, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		info.Piece = &PieceInfo{
+			SetID:  hex.EncodeToString(p.setID[:]),
+			Index:  p.index,
+			Count:  p.count,
+			Length: int(binary.LittleEndian.Uint32(header[20:24])),
+			Total:  p.total,
+		}
+		info.Present = true
+		if p.index != 0 {
+			return info, nil
+		}
+		header = p.data
+	}
+
+	gdp, err := ParseGDPFile(header, true)
+	if err != nil {
+		return nil, err
+	}
+	info.Present = true
+	info.Header = headerInfo(gdp)
+
+	return info, nil
+}
+
+// headerInfo summarises a parsed GDP header.
+func headerInfo(gdp *GDPFile) *HeaderInfo {
+	header := &HeaderInfo{
+		Version:        gdp.Version,
+		Encrypted:      gdp.Encryption,
+		Compression:    compressionName(gdp.Compression),
+		Content:        contentName(gdp.Content),
+		NonceSize:      len(gdp.Nonce),
+		HeaderSize:     gdp.HeaderSize,
+		CiphertextSize: gdp.CiphertextSize,
+	}
+	if gdp.Encryption {
+		header.Cipher = CipherName(gdp.Cipher)
+		kdf := gdp.KDF
+		header.KDF = &KDFInfo{
+			Algorithm: KDFName(kdf.Algorithm),
+			Time:      kdf.Time,
+			MemoryKiB: kdf.Memory,
+			Threads:   kdf.Threads,
+			SaltSize:  len(kdf.Salt),
+		}
+	}
+	for _, ext := range gdp.Extensions {
+		switch ext.Type {
+		case ExtFilename:
+			header.Filename = string(ext.Value)
+		case ExtMIMEType:
+			header.MIMEType = string(ext.Value)
+		case ExtChecksum:
+			if len(ext.Value) > 0 {
+				header.Checksum = ChecksumName(ext.Value[0])
+			}
+		case ExtRecipient:
+			header.Recipients++
+		case ExtPadding:
+			header.Padded = true
+		case ExtSealedSig:
+			header.Signed = true
+		case ExtSignature:
+			header.Signed = true
+			if len(ext.Value) == signatureFieldSize {
+				header.Signer = (&VerifyingKey{key: ext.Value[:ed25519.PublicKeySize]}).String()
+			}
+		case ExtShare:
+			if share, err := parseShare(ext.Value); err == nil {
+				header.Share = &ShareInfo{
+					SetID:     hex.EncodeToString(share.setID[:]),
+					Index:     share.index,
+					Count:     share.count,
+					Threshold: share.threshold,
+				}
+			}
+		default:
+			header.Extensions = append(header.Extensions, ext.Type)
+		}
+	}
+	return header
+}
+
+// chunkIDs lists the RIFF chunks in a WAV header read up to the data chunk.
+func chunkIDs(header []byte) []string {
+	var ids []string
+	for offset := 12; offset+8 <= len(header); {
+		ids = append(ids, string(header[offset:offset+4]))
+		size := int(binary.LittleEndian.Uint32(header[offset+4 : offset+8]))
+		if string(header[offset:offset+4]) == "data" {
+			break
+		}
+		offset += 8 + size + size%2
+	}
+	return ids
+}
+
+// compressionName returns a readable name for a compression algorithm.
+func compressionName(compression uint8) string {
+	switch compression {
+	case CompressionNone:
+		return "none"
+	case CompressionXZ:
+		return "xz"
+	default:
+		return fmt.Sprintf("unknown (%d)", compression)
+	}
+}
+
+// contentName returns a readable name for a payload content type.
+func contentName(content uint8) string {
+	switch content {
+	case ContentRaw:
+		return "raw"
+	case ContentTar:
+		return "tar"
+	default:
+		return fmt.Sprintf("unknown (%d)", content)
+	}
+}
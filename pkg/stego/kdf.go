@@ -0,0 +1,226 @@
+package stego
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KDF identifiers stored in the GDP header.
+const (
+	KDFNone     uint8 = 0 // No key derivation, the payload is not encrypted or its key comes from shares or recipients
+	KDFPBKDF2   uint8 = 1 // PBKDF2-HMAC-SHA256
+	KDFArgon2id uint8 = 2 // Argon2id
+	KDFScrypt   uint8 = 3 // scrypt with r = 8
+)
+
+const (
+	// SaltSize is the size of the random salt generated for every container.
+	SaltSize = 16
+
+	// DefaultPBKDF2Iterations is the PBKDF2-SHA256 work factor for new containers.
+	DefaultPBKDF2Iterations = 600000
+
+	// Argon2id defaults follow the second recommendation of RFC 9106.
+	DefaultArgon2Time    = 3
+	DefaultArgon2Memory  = 64 * 1024 // KiB
+	DefaultArgon2Threads = 4
+
+	// scrypt defaults to N = 2^17, which with r = 8 uses 128 MiB.
+	DefaultScryptMemory  = 128 * 1024 // KiB
+	DefaultScryptThreads = 1
+
+	// Limits on the work a crafted header can ask for. The memory limit is 16
+	// times the Argon2id default and 8 times the scrypt one, N = 2^20.
+	maxPBKDF2Iterations = 100000000
+	maxKDFTime          = 64
+	maxKDFMemory        = 1024 * 1024 // KiB
+	maxScryptThreads    = 16
+
+	// scryptR is the scrypt block size. With r = 8 every unit of N costs 1 KiB,
+	// so the memory cost in KiB is N itself.
+	scryptR = 8
+
+	keySize = 32
+)
+
+// kdfNames maps the names accepted on the command line to KDF identifiers.
+var kdfNames = map[string]uint8{
+	"pbkdf2":   KDFPBKDF2,
+	"argon2id": KDFArgon2id,
+	"scrypt":   KDFScrypt,
+}
+
+// KDFParams describes how the encryption key is derived from the password.
+type KDFParams struct {
+	Algorithm uint8
+	Time      uint32 // Iterations for PBKDF2, passes for Argon2id, 0 for scrypt
+	Memory    uint32 // Memory cost in KiB, N for scrypt, unused by PBKDF2
+	Threads   uint8  // Parallelism (p for scrypt), unused by PBKDF2
+	Salt      []byte
+}
+
+// DefaultKDFParams returns the key derivation settings used for new containers, without a salt.
+func DefaultKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm: KDFPBKDF2,
+		Time:      DefaultPBKDF2Iterations,
+	}
+}
+
+// NewKDFParams returns the default settings for the KDF with the given name.
+func NewKDFParams(name string) (KDFParams, error) {
+	algorithm, ok := kdfNames[strings.ToLower(name)]
+	if !ok {
+		return KDFParams{}, fmt.Errorf("unknown KDF %q: must be pbkdf2, argon2id or scrypt", name)
+	}
+
+	switch algorithm {
+	case KDFArgon2id:
+		return KDFParams{Algorithm: KDFArgon2id, Time: DefaultArgon2Time, Memory: DefaultArgon2Memory, Threads: DefaultArgon2Threads}, nil
+	case KDFScrypt:
+		return KDFParams{Algorithm: KDFScrypt, Memory: DefaultScryptMemory, Threads: DefaultScryptThreads}, nil
+	default:
+		return DefaultKDFParams(), nil
+	}
+}
+
+// KDFName returns a readable name for a KDF identifier.
+func KDFName(algorithm uint8) string {
+	switch algorithm {
+	case KDFNone:
+		return "none"
+	case KDFPBKDF2:
+		return "PBKDF2-SHA256"
+	case KDFArgon2id:
+		return "Argon2id"
+	case KDFScrypt:
+		return "scrypt"
+	default:
+		return fmt.Sprintf("unknown (%d)", algorithm)
+	}
+}
+
+// ParseMemorySize parses a memory cost such as "256MiB", "1GiB" or "65536" (KiB) into KiB.
+func ParseMemorySize(size string) (uint32, error) {
+	units := []struct {
+		suffix string
+		kib    uint64
+	}{
+		{"gib", 1024 * 1024}, {"gb", 1024 * 1024}, {"g", 1024 * 1024},
+		{"mib", 1024}, {"mb", 1024}, {"m", 1024},
+		{"kib", 1}, {"kb", 1}, {"k", 1},
+	}
+
+	value := strings.ToLower(strings.TrimSpace(size))
+	multiplier := uint64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.kib
+			break
+		}
+	}
+
+	n, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory size %q", size)
+	}
+	if n*multiplier > maxKDFMemory {
+		return 0, fmt.Errorf("memory size %q exceeds the 1GiB limit", size)
+	}
+
+	return uint32(n * multiplier), nil
+}
+
+// GenerateSalt returns a fresh random salt.
+func GenerateSalt() ([]byte, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return salt, nil
+}
+
+// ValidateKDFParams checks that the parameters describe a usable key derivation.
+func ValidateKDFParams(params KDFParams) error {
+	switch params.Algorithm {
+	case KDFPBKDF2:
+		if params.Time == 0 || params.Time > maxPBKDF2Iterations {
+			return fmt.Errorf("invalid PBKDF2 iterations: %d", params.Time)
+		}
+	case KDFArgon2id:
+		if params.Time == 0 || params.Time > maxKDFTime {
+			return fmt.Errorf("invalid Argon2id time: %d", params.Time)
+		}
+		if params.Threads == 0 {
+			return errors.New("invalid Argon2id threads: 0")
+		}
+		if params.Memory < 8*uint32(params.Threads) || params.Memory > maxKDFMemory {
+			return fmt.Errorf("invalid Argon2id memory: %d KiB", params.Memory)
+		}
+	case KDFScrypt:
+		if params.Time != 0 {
+			return fmt.Errorf("invalid scrypt time: %d (scrypt has no time cost)", params.Time)
+		}
+		if params.Memory < 2 || params.Memory > maxKDFMemory || bits.OnesCount32(params.Memory) != 1 {
+			return fmt.Errorf("invalid scrypt memory: %d KiB (must be a power of two)", params.Memory)
+		}
+		if params.Threads == 0 || params.Threads > maxScryptThreads {
+			return fmt.Errorf("invalid scrypt threads: %d", params.Threads)
+		}
+	default:
+		return fmt.Errorf("unsupported KDF: %d", params.Algorithm)
+	}
+
+	if len(params.Salt) == 0 {
+		return errors.New("missing KDF salt")
+	}
+
+	return nil
+}
+
+// DeriveKeyWithParams derives the encryption key from a password using the given KDF parameters.
+func DeriveKeyWithParams(password string, params KDFParams) ([]byte, error) {
+	if err := ValidateKDFParams(params); err != nil {
+		return nil, err
+	}
+
+	switch params.Algorithm {
+	case KDFArgon2id:
+		return argon2.IDKey([]byte(password), params.Salt, params.Time, params.Memory, params.Threads, keySize), nil
+	case KDFScrypt:
+		key, err := scrypt.Key([]byte(password), params.Salt, int(params.Memory), scryptR, int(params.Threads), keySize)
+		if err != nil {
+			return nil, fmt.Errorf("scrypt failed: %w", err)
+		}
+		return key, nil
+	default:
+		return pbkdf2.Key([]byte(password), params.Salt, int(params.Time), keySize, sha256.New), nil
+	}
+}
+
+// LegacyKDFParams returns the fixed salt and work factor used by version 1 GDP headers.
+func LegacyKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm: KDFPBKDF2,
+		Time:      100000,
+		Salt:      []byte("GoDeepSalt"),
+	}
+}
+
+// DeriveKey derives a key with the legacy fixed salt. It also seeds scattered
+// placement, which has to be found before the GDP header can be read.
+func DeriveKey(password string) []byte {
+	params := LegacyKDFParams()
+	return pbkdf2.Key([]byte(password), params.Salt, int(params.Time), keySize, sha256.New)
+}
@@ -0,0 +1,137 @@
+package stego
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Padded plaintext
+// ---------------------------------------------------------
+// Length  : 8 bytes (uint64), size of the compressed payload
+// Payload : the compressed payload
+// Padding : random bytes up to the padded size
+// ---------------------------------------------------------
+//
+// A payload padded to a bucket is rounded up to a multiple of the bucket
+// size, so the ciphertext size only tells which bucket it falls in. Padding
+// to fill the container makes the frame take every bit of it, so comparing
+// the carrier with the original shows nothing about the length either. The
+// padding is added before encryption, so the authentication tag covers it,
+// and the ExtPadding field marks the plaintext as padded. It is critical:
+// a reader that does not know it would decompress the length prefix.
+
+// PadFill pads the payload to fill the container, for EmbedOptions.Padding.
+const PadFill = -1
+
+const padLengthSize = 8
+
+// ParsePadding reads a padding size as given on the command line: "fill",
+// or a bucket size in bytes with an optional K, M or G suffix (KiB, MiB and
+// GiB are accepted too). "0" and "none" turn padding off.
+func ParsePadding(value string) (int, error) {
+	s := strings.TrimSpace(strings.ToLower(value))
+	switch s {
+	case "fill":
+		return PadFill, nil
+	case "", "none":
+		return 0, nil
+	}
+
+	number, unit := s, 1
+	for _, suffix := range []struct {
+		name string
+		unit int
+	}{
+		{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30},
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30},
+		{"b", 1},
+	} {
+		if trimmed, ok := strings.CutSuffix(s, suffix.name); ok {
+			number, unit = strings.TrimSpace(trimmed), suffix.unit
+			break
+		}
+	}
+
+	size, err := strconv.Atoi(number)
+	if err != nil || size < 0 || size > (1<<40)/unit {
+		return 0, fmt.Errorf("invalid padding %q, expected fill or a size such as 64K", value)
+	}
+	return size * unit, nil
+}
+
+// padPayload frames the compressed payload of a GDP file, whose header
+// fields are all set, and pads it as opts asks. With PadFill the padded size
+// is what leaves the frame filling opts.fill bytes of the container.
+func padPayload(compressed []byte, gdp *GDPFile, opts EmbedOptions) ([]byte, error) {
+	size := padLengthSize + len(compressed)
+	switch {
+	case opts.Padding == PadFill:
+		if opts.fill == 0 {
+			return nil, errors.New("padding to fill needs the size of a single container, use a bucket size instead")
+		}
+		// The header is the same size once the nonce and ciphertext are in
+		header := *gdp
+		overhead := 0
+		if gdp.Encryption {
+			var nonceSize int
+			nonceSize, overhead = cipherSizes(gdp.Cipher)
+			header.Nonce = make([]byte, nonceSize)
+		}
+		if _, sealed := gdp.Extension(ExtSealedSig); sealed {
+			overhead += signatureFieldSize
+		}
+		headerFile, err := MakeGDPFile(header)
+		if err != nil {
+			return nil, err
+		}
+		headerSize := len(headerFile)
+		filled := frameCapacity(opts.fill, headerSize, opts) - headerSize - overhead
+		if filled < size {
+			return nil, fmt.Errorf("%w: %d bytes needed, %d available", ErrCapacity, size+headerSize+overhead, filled+headerSize+overhead)
+		}
+		size = filled
+	case opts.Padding > 0:
+		size = (size + opts.Padding - 1) / opts.Padding * opts.Padding
+	default:
+		return nil, fmt.Errorf("invalid padding %d", opts.Padding)
+	}
+
+	padded := make([]byte, size)
+	binary.LittleEndian.PutUint64(padded, uint64(len(compressed)))
+	copy(padded[padLengthSize:], compressed)
+	if _, err := rand.Read(padded[padLengthSize+len(compressed):]); err != nil {
+		return nil, fmt.Errorf("failed to generate padding: %w", err)
+	}
+	return padded, nil
+}
+
+// unpadPayload returns the compressed payload framed by padPayload.
+func unpadPayload(padded []byte) ([]byte, error) {
+	if len(padded) < padLengthSize {
+		return nil, errors.New("invalid GDP file: padded payload too short")
+	}
+	length := binary.LittleEndian.Uint64(padded)
+	if length > uint64(len(padded)-padLengthSize) {
+		return nil, errors.New("invalid GDP file: padded payload length exceeds its size")
+	}
+	return padded[padLengthSize : padLengthSize+int(length)], nil
+}
+
+// fillCapacity returns the bytes a frame may take in a container file at the
+// depth and placement in opts, for padding it to fill the container.
+func fillCapacity(container string, opts EmbedOptions) (int, error) {
+	samples, err := containerSamples(container)
+	if err != nil {
+		return 0, err
+	}
+	depth := opts.Depth
+	if depth == 0 {
+		depth = MinDepth
+	}
+	return int(frameRoom(samples, depth, opts)), nil
+}
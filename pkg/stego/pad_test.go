@@ -0,0 +1,57 @@
+package stego
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestPadPayload rounds the framed payload up to the bucket and back.
+func TestPadPayload(t *testing.T) {
+	for _, size := range []int{0, 1, 4096 - padLengthSize, 4096 - padLengthSize + 1, 10000} {
+		compressed := randomBytes(size, uint64(size))
+		padded, err := padPayload(compressed, &GDPFile{}, EmbedOptions{Padding: 4096})
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		want := (padLengthSize + size + 4095) / 4096 * 4096
+		if len(padded) != want {
+			t.Fatalf("%d bytes padded to %d, want %d", size, len(padded), want)
+		}
+		unpadded, err := unpadPayload(padded)
+		if err != nil || !bytes.Equal(unpadded, compressed) {
+			t.Fatalf("%d bytes did not round-trip: %v", size, err)
+		}
+	}
+
+	// Filling needs the room in the container, which only Embed knows
+	if _, err := padPayload([]byte("data"), &GDPFile{}, EmbedOptions{Padding: PadFill}); err == nil {
+		t.Fatal("padding to fill without a container was accepted")
+	}
+}
+
+// TestUnpadPayload rejects a length prefix that does not fit.
+func TestUnpadPayload(t *testing.T) {
+	for name, padded := range map[string][]byte{
+		"too short":   {1, 2, 3},
+		"length over": {9, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8},
+		"huge length": {0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 1},
+	} {
+		if _, err := unpadPayload(padded); err == nil {
+			t.Fatalf("%s: unpadPayload accepted it", name)
+		}
+	}
+}
+
+// TestParsePadding reads the --pad values.
+func TestParsePadding(t *testing.T) {
+	for value, want := range map[string]int{"": 0, "fill": PadFill, "4096": 4096, "64K": 64 * 1024, "1M": 1024 * 1024} {
+		if got, err := ParsePadding(value); err != nil || got != want {
+			t.Fatalf("ParsePadding(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"-1", "lots", "2T"} {
+		if _, err := ParsePadding(value); err == nil {
+			t.Fatalf("ParsePadding(%q) accepted it", value)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package stego
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Recipient stanzas
+// ---------------------------------------------------------
+// Ephemeral key : 32 bytes, an X25519 public key made for this recipient
+// Wrapped key   : 48 bytes, the file key sealed with AES-256-GCM
+// ---------------------------------------------------------
+//
+// With recipients the payload is encrypted under a random file key instead of
+// a password, and the header holds one ExtRecipient field per recipient, as
+// age does. The wrapping key is HKDF-SHA256 over the X25519 shared secret of
+// the ephemeral key and the recipient's key, salted with both public keys.
+// Each wrapping key is used once, so the GCM nonce is zero. Extraction tries
+// every identity it is given on every stanza.
+
+const (
+	recipientPrefix = "godeep-pub-"
+	identityPrefix  = "GODEEP-SECRET-"
+
+	recipientStanzaSize = 32 + keySize + 16
+	recipientLabel      = "GoDeep X25519"
+)
+
+// Recipient is an X25519 public key a payload can be encrypted to.
+type Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// Identity is the X25519 private key that opens payloads encrypted to its Recipient.
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateIdentity creates a new random identity.
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+// Recipient returns the public key matching the identity.
+func (i *Identity) Recipient() *Recipient {
+	return &Recipient{key: i.key.PublicKey()}
+}
+
+// String encodes the identity, which must be kept secret.
+func (i *Identity) String() string {
+	return identityPrefix + base64.RawURLEncoding.EncodeToString(i.key.Bytes())
+}
+
+// String encodes the recipient as it is shared with others.
+func (r *Recipient) String() string {
+	return recipientPrefix + base64.RawURLEncoding.EncodeToString(r.key.Bytes())
+}
+
+// ParseRecipient decodes a recipient written by Recipient.String.
+func ParseRecipient(s string) (*Recipient, error) {
+	key, err := parseKey(s, recipientPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient: %w", err)
+	}
+	public, err := ecdh.X25519().NewPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recipient: %w", err)
+	}
+	return &Recipient{key: public}, nil
+}
+
+// ParseIdentity decodes an identity written by Identity.String.
+func ParseIdentity(s string) (*Identity, error) {
+	key, err := parseKey(s, identityPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity: %w", err)
+	}
+	private, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity: %w", err)
+	}
+	return &Identity{key: private}, nil
+}
+
+// parseKey decodes a 32-byte key after its prefix.
+func parseKey(s string, prefix string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("expected a key starting with %s", prefix)
+	}
+	key, err := base64.RawURLEncoding.DecodeString(s[len(prefix):])
+	if err != nil || len(key) != keySize {
+		return nil, errors.New("malformed key")
+	}
+	return key, nil
+}
+
+// ReadKeys reads recipients, identities or signing keys from a key string or a file holding
+// one per line. Blank lines and lines starting with # are skipped.
+func ReadKeys(source string) ([]string, error) {
+	for _, prefix := range []string{recipientPrefix, identityPrefix, signerPrefix, signingKeyPrefix} {
+		if strings.HasPrefix(source, prefix) {
+			return []string{source}, nil
+		}
+	}
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			keys = append(keys, line)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in %s", source)
+	}
+	return keys, nil
+}
+
+// wrapKey derives the key that seals the file key for one stanza.
+func wrapKey(shared []byte, ephemeral []byte, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte(nil), ephemeral...), recipient...)
+	key := make([]byte, keySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(recipientLabel)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptToRecipient seals a file key for one recipient and returns the stanza.
+func encryptToRecipient(fileKey []byte, recipient *Recipient) ([]byte, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient.key)
+	if err != nil {
+		return nil, err
+	}
+	public := ephemeral.PublicKey().Bytes()
+	aead, err := wrapKey(shared, public, recipient.key.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(public, make([]byte, aead.NonceSize()), fileKey, nil), nil
+}
+
+// decryptStanzas recovers the file key from the first stanza one of the
+// identities can open.
+func decryptStanzas(stanzas [][]byte, identities []*Identity) ([]byte, error) {
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("%w: the payload is encrypted to recipients, an identity is required", ErrAuthFailed)
+	}
+	for _, stanza := range stanzas {
+		if len(stanza) != recipientStanzaSize {
+			return nil, errors.New("invalid GDP file: bad recipient field")
+		}
+		ephemeral, err := ecdh.X25519().NewPublicKey(stanza[:32])
+		if err != nil {
+			return nil, errors.New("invalid GDP file: bad recipient field")
+		}
+		for _, identity := range identities {
+			shared, err := identity.key.ECDH(ephemeral)
+			if err != nil {
+				continue
+			}
+			aead, err := wrapKey(shared, stanza[:32], identity.key.PublicKey().Bytes())
+			if err != nil {
+				return nil, err
+			}
+			if fileKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), stanza[32:], nil); err == nil {
+				return fileKey, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: none of the identities matches the %d recipients", ErrAuthFailed, len(stanzas))
+}
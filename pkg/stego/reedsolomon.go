@@ -0,0 +1,232 @@
+package stego
+
+import "errors"
+
+// Reed–Solomon codes over GF(2^8) with the primitive polynomial 0x11d and
+// generator roots α^0 … α^(nsym-1). Polynomials are byte slices with the
+// highest degree first, so a codeword is the message followed by its parity.
+// A codeword with nsym parity symbols corrects up to nsym/2 byte errors; it
+// may be shorter than 255 bytes, which behaves as if padded with leading zeros.
+
+// errTooManyErrors reports a codeword damaged beyond what its parity can repair.
+var errTooManyErrors = errors.New("too many errors to correct")
+
+var gfExp [512]byte
+var gfLog [256]byte
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	// Doubling the table saves a modulo in gfMul
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(x, y byte) byte {
+	if x == 0 || y == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[x])+int(gfLog[y])]
+}
+
+func gfDiv(x, y byte) byte {
+	if x == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[x])+255-int(gfLog[y]))%255]
+}
+
+// gfPow raises x to a power, which may be negative.
+func gfPow(x byte, power int) byte {
+	exponent := (int(gfLog[x]) * power) % 255
+	if exponent < 0 {
+		exponent += 255
+	}
+	return gfExp[exponent]
+}
+
+func gfInverse(x byte) byte {
+	return gfExp[255-int(gfLog[x])]
+}
+
+func gfPolyScale(p []byte, x byte) []byte {
+	r := make([]byte, len(p))
+	for i, c := range p {
+		r[i] = gfMul(c, x)
+	}
+	return r
+}
+
+func gfPolyAdd(p, q []byte) []byte {
+	r := make([]byte, max(len(p), len(q)))
+	for i, c := range p {
+		r[i+len(r)-len(p)] = c
+	}
+	for i, c := range q {
+		r[i+len(r)-len(q)] ^= c
+	}
+	return r
+}
+
+func gfPolyMul(p, q []byte) []byte {
+	r := make([]byte, len(p)+len(q)-1)
+	for j, b := range q {
+		for i, a := range p {
+			r[i+j] ^= gfMul(a, b)
+		}
+	}
+	return r
+}
+
+func gfPolyEval(p []byte, x byte) byte {
+	y := p[0]
+	for _, c := range p[1:] {
+		y = gfMul(y, x) ^ c
+	}
+	return y
+}
+
+// gfPolyRemainder returns the remainder of dividing p by a monic divisor.
+func gfPolyRemainder(p, divisor []byte) []byte {
+	r := append([]byte(nil), p...)
+	for i := 0; i < len(p)-(len(divisor)-1); i++ {
+		if c := r[i]; c != 0 {
+			for j := 1; j < len(divisor); j++ {
+				r[i+j] ^= gfMul(divisor[j], c)
+			}
+		}
+	}
+	return r[len(r)-(len(divisor)-1):]
+}
+
+// rsGenerator returns the generator polynomial for nsym parity symbols.
+func rsGenerator(nsym int) []byte {
+	g := []byte{1}
+	for i := 0; i < nsym; i++ {
+		g = gfPolyMul(g, []byte{1, gfPow(2, i)})
+	}
+	return g
+}
+
+// rsEncode returns the message followed by nsym parity symbols.
+func rsEncode(message []byte, nsym int) []byte {
+	generator := rsGenerator(nsym)
+	codeword := make([]byte, len(message)+nsym)
+	copy(codeword, message)
+	for i := range message {
+		if c := codeword[i]; c != 0 {
+			for j := 1; j < len(generator); j++ {
+				codeword[i+j] ^= gfMul(generator[j], c)
+			}
+		}
+	}
+	copy(codeword, message)
+	return codeword
+}
+
+// rsSyndromes evaluates the codeword at each generator root, with a leading
+// zero so the indices line up with the error locator.
+func rsSyndromes(codeword []byte, nsym int) ([]byte, bool) {
+	syndromes := make([]byte, nsym+1)
+	clean := true
+	for i := 0; i < nsym; i++ {
+		syndromes[i+1] = gfPolyEval(codeword, gfPow(2, i))
+		clean = clean && syndromes[i+1] == 0
+	}
+	return syndromes, clean
+}
+
+// rsDecode corrects a codeword in place and returns the number of bytes it fixed.
+func rsDecode(codeword []byte, nsym int) (int, error) {
+	syndromes, clean := rsSyndromes(codeword, nsym)
+	if clean {
+		return 0, nil
+	}
+
+	// Berlekamp–Massey finds the error locator polynomial
+	locator, old := []byte{1}, []byte{1}
+	for i := 0; i < nsym; i++ {
+		k := i + 1
+		delta := syndromes[k]
+		for j := 1; j < len(locator); j++ {
+			delta ^= gfMul(locator[len(locator)-1-j], syndromes[k-j])
+		}
+		old = append(old, 0)
+		if delta != 0 {
+			if len(old) > len(locator) {
+				locator, old = gfPolyScale(old, delta), gfPolyScale(locator, gfInverse(delta))
+			}
+			locator = gfPolyAdd(locator, gfPolyScale(old, delta))
+		}
+	}
+	for len(locator) > 0 && locator[0] == 0 {
+		locator = locator[1:]
+	}
+	errs := len(locator) - 1
+	if errs*2 > nsym {
+		return 0, errTooManyErrors
+	}
+
+	// Chien search finds the positions of the errors
+	reversed := make([]byte, len(locator))
+	for i, c := range locator {
+		reversed[len(locator)-1-i] = c
+	}
+	var positions []int
+	for i := 0; i < len(codeword); i++ {
+		if gfPolyEval(reversed, gfPow(2, i)) == 0 {
+			positions = append(positions, len(codeword)-1-i)
+		}
+	}
+	if len(positions) != errs {
+		return 0, errTooManyErrors
+	}
+
+	// Forney's algorithm finds their magnitudes
+	coefficients := make([]int, len(positions))
+	errata := []byte{1}
+	for i, position := range positions {
+		coefficients[i] = len(codeword) - 1 - position
+		errata = gfPolyMul(errata, gfPolyAdd([]byte{1}, []byte{gfPow(2, coefficients[i]), 0}))
+	}
+	reversedSyndromes := make([]byte, len(syndromes))
+	for i, s := range syndromes {
+		reversedSyndromes[len(syndromes)-1-i] = s
+	}
+	divisor := make([]byte, len(errata)+1)
+	divisor[0] = 1
+	evaluator := gfPolyRemainder(gfPolyMul(reversedSyndromes, errata), divisor)
+
+	roots := make([]byte, len(coefficients))
+	for i, coefficient := range coefficients {
+		roots[i] = gfPow(2, coefficient)
+	}
+	for i, root := range roots {
+		inverse := gfInverse(root)
+		derivative := byte(1)
+		for j, other := range roots {
+			if j != i {
+				derivative = gfMul(derivative, 1^gfMul(inverse, other))
+			}
+		}
+		if derivative == 0 {
+			return 0, errTooManyErrors
+		}
+		y := gfMul(root, gfPolyEval(evaluator, inverse))
+		codeword[positions[i]] ^= gfDiv(y, derivative)
+	}
+
+	// A miscorrection leaves the syndromes non-zero
+	if _, clean := rsSyndromes(codeword, nsym); !clean {
+		return 0, errTooManyErrors
+	}
+	return errs, nil
+}
@@ -0,0 +1,84 @@
+package stego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/rand/v2"
+	"testing"
+)
+
+// randomBytes returns size bytes that depend only on seed.
+func randomBytes(size int, seed uint64) []byte {
+	var key [32]byte
+	binary.LittleEndian.PutUint64(key[:], seed)
+	data := make([]byte, size)
+	rand.NewChaCha8(key).Read(data)
+	return data
+}
+
+// TestRSDecode corrects up to nsym/2 damaged bytes and refuses more.
+func TestRSDecode(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for _, test := range []struct{ size, nsym int }{{5, 16}, {223, 32}, {253, 2}, {127, 128}} {
+		message := randomBytes(test.size, uint64(test.size))
+		codeword := rsEncode(message, test.nsym)
+		if len(codeword) != test.size+test.nsym || !bytes.Equal(codeword[:test.size], message) {
+			t.Fatalf("RS(%d,%d): codeword does not start with the message", test.size+test.nsym, test.size)
+		}
+		if n, err := rsDecode(append([]byte(nil), codeword...), test.nsym); n != 0 || err != nil {
+			t.Fatalf("RS(%d,%d): clean codeword: %d corrected, %v", test.size+test.nsym, test.size, n, err)
+		}
+
+		// Any nsym/2 damaged bytes are repaired
+		damaged := append([]byte(nil), codeword...)
+		for _, i := range rng.Perm(len(damaged))[:test.nsym/2] {
+			damaged[i] ^= byte(1 + rng.IntN(255))
+		}
+		n, err := rsDecode(damaged, test.nsym)
+		if err != nil || n != test.nsym/2 || !bytes.Equal(damaged, codeword) {
+			t.Fatalf("RS(%d,%d): %d errors: %d corrected, %v", test.size+test.nsym, test.size, test.nsym/2, n, err)
+		}
+	}
+
+	// More errors than the parity can repair are reported
+	codeword := rsEncode([]byte("GoDeep Reed-Solomon"), 4)
+	codeword[0] ^= 0x01
+	codeword[5] ^= 0x10
+	codeword[9] ^= 0xFF
+	if _, err := rsDecode(codeword, 4); !errors.Is(err, errTooManyErrors) {
+		t.Fatalf("3 errors with 4 parity bytes: got %v, want errTooManyErrors", err)
+	}
+}
+
+// TestFECFrame repairs a burst spread over the interleaved blocks.
+func TestFECFrame(t *testing.T) {
+	gdpFile := randomBytes(3000, 3)
+	frame := encodeFEC(gdpFile, 16)
+	if len(frame) != FECSize(len(gdpFile), 16) {
+		t.Fatalf("frame of %d bytes, FECSize says %d", len(frame), FECSize(len(gdpFile), 16))
+	}
+	if size, err := fecFrameSize(frame); err != nil || size != len(frame) {
+		t.Fatalf("fecFrameSize: %d, %v, want %d", size, err, len(frame))
+	}
+
+	// A burst of 8 bytes per block, and damage to the header, are repaired
+	blocks := fecBlocks(len(gdpFile), 16)
+	for i := 0; i < 8*blocks; i++ {
+		frame[fecHeaderSize+1000+i] ^= 0xA5
+	}
+	frame[0] ^= 0xFF
+	frame[3] ^= 0x01
+	decoded, parity, corrected, err := decodeFEC(frame)
+	if err != nil || parity != 16 || corrected != 8*blocks+2 || !bytes.Equal(decoded, gdpFile) {
+		t.Fatalf("decodeFEC: parity %d, %d corrected, %v", parity, corrected, err)
+	}
+
+	// A header damaged beyond repair is no frame at all
+	for i := 0; i < 9; i++ {
+		frame[i] ^= 0x5A
+	}
+	if _, _, _, err := decodeFEC(frame); !errors.Is(err, ErrBadMagic) {
+		t.Fatalf("decodeFEC with a ruined header: got %v, want ErrBadMagic", err)
+	}
+}
@@ -0,0 +1,244 @@
+package stego
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Key shares
+// ---------------------------------------------------------
+// Set ID    : 8 bytes, random, shared by every share of a set
+// Threshold : 1 byte (uint8), shares needed to recover the key
+// Count     : 1 byte (uint8), shares in the set
+// Index     : 1 byte (uint8), x coordinate of this share, from 1
+// Share     : 32 bytes, the share of the secret
+// ---------------------------------------------------------
+//
+// With key sharing the payload is encrypted under a random 32-byte secret,
+// which is cut into shares with Shamir's scheme over GF(2^8). Each container
+// holds the whole GDP file with one share in its ExtShare field, so any
+// Threshold of them recover the secret and decrypt it; fewer reveal nothing
+// about it. With a password as well, the key is an HMAC of the secret under
+// the password's key, and both the shares and the password are needed.
+
+// MaxShares is the largest number of containers a key can be shared across.
+const MaxShares = 255
+
+const shareHeaderSize = 11
+
+// keyShare is one share of a secret as stored in the ExtShare field.
+type keyShare struct {
+	setID     [8]byte
+	threshold int
+	count     int
+	index     int
+	value     []byte
+}
+
+// ValidateThreshold checks that threshold out of count shares is a usable split.
+// A threshold of 1 is refused: every share would be the key itself, stored in
+// the clear next to the ciphertext.
+func ValidateThreshold(threshold int, count int) error {
+	if count < 2 || count > MaxShares {
+		return fmt.Errorf("invalid number of shares %d: must be between 2 and %d containers", count, MaxShares)
+	}
+	if threshold < 2 || threshold > count {
+		return fmt.Errorf("invalid threshold %d: must be between 2 and the number of containers, %d", threshold, count)
+	}
+	return nil
+}
+
+// splitSecret cuts a secret into count shares, any threshold of which recover it.
+func splitSecret(secret []byte, threshold int, count int) ([][]byte, error) {
+	shares := make([][]byte, count)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+
+	// Each byte gets its own polynomial with the secret byte as constant term
+	coefficients := make([]byte, threshold-1)
+	for b, s := range secret {
+		if _, err := rand.Read(coefficients); err != nil {
+			return nil, fmt.Errorf("failed to generate shares: %w", err)
+		}
+		for i := range shares {
+			x := byte(i + 1)
+			y := byte(0)
+			for j := len(coefficients) - 1; j >= 0; j-- {
+				y = gfMul(y, x) ^ coefficients[j]
+			}
+			shares[i][b] = gfMul(y, x) ^ s
+		}
+	}
+	return shares, nil
+}
+
+// combineShares recovers the secret from threshold shares by Lagrange
+// interpolation at zero.
+func combineShares(shares []*keyShare) []byte {
+	secret := make([]byte, len(shares[0].value))
+	for i, share := range shares {
+		// The Lagrange basis polynomial of this share, evaluated at zero
+		basis := byte(1)
+		xi := byte(share.index)
+		for j, other := range shares {
+			if j != i {
+				xj := byte(other.index)
+				basis = gfMul(basis, gfDiv(xj, xj^xi))
+			}
+		}
+		for b, y := range share.value {
+			secret[b] ^= gfMul(y, basis)
+		}
+	}
+	return secret
+}
+
+// encodeShare builds the value of an ExtShare field.
+func encodeShare(share keyShare) []byte {
+	value := make([]byte, 0, shareHeaderSize+len(share.value))
+	value = append(value, share.setID[:]...)
+	value = append(value, byte(share.threshold), byte(share.count), byte(share.index))
+	return append(value, share.value...)
+}
+
+// parseShare reads the value of an ExtShare field.
+func parseShare(value []byte) (*keyShare, error) {
+	if len(value) != shareHeaderSize+keySize {
+		return nil, errors.New("invalid GDP file: bad key share field")
+	}
+	share := &keyShare{
+		threshold: int(value[8]),
+		count:     int(value[9]),
+		index:     int(value[10]),
+		value:     value[shareHeaderSize:],
+	}
+	copy(share.setID[:], value[:8])
+	if share.index == 0 || share.index > share.count || share.threshold == 0 || share.threshold > share.count {
+		return nil, errors.New("invalid GDP file: bad key share field")
+	}
+	return share, nil
+}
+
+// shareKey combines the secret recovered from the shares with the key derived
+// from a password, when there is one.
+func shareKey(passwordKey []byte, secret []byte) []byte {
+	if passwordKey == nil {
+		return secret
+	}
+	mac := hmac.New(sha256.New, passwordKey)
+	mac.Write(secret)
+	return mac.Sum(nil)
+}
+
+// recoverSecret collects the key shares of the given GDP files and recovers
+// the secret they were cut from. It names the set when too few are given.
+func recoverSecret(gdpFiles [][]byte) ([]byte, int, error) {
+	var shares []*keyShare
+	seen := make(map[int]bool)
+	for _, gdpFile := range gdpFiles {
+		gdp, err := ParseGDPFile(gdpFile, true)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, ok := gdp.Extension(ExtShare)
+		if !ok {
+			return nil, 0, errors.New("the containers mix key shares with other payloads")
+		}
+		share, err := parseShare(value)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(shares) > 0 && (share.setID != shares[0].setID || share.threshold != shares[0].threshold) {
+			return nil, 0, fmt.Errorf("the key shares belong to different sets (%x and %x)", shares[0].setID, share.setID)
+		}
+		if !seen[share.index] {
+			seen[share.index] = true
+			shares = append(shares, share)
+		}
+	}
+
+	first := shares[0]
+	if len(shares) < first.threshold {
+		return nil, 0, fmt.Errorf("%w: %d of the %d key shares of set %x given, %d are needed",
+			ErrIncomplete, len(shares), first.count, first.setID, first.threshold)
+	}
+	return combineShares(shares[:first.threshold]), len(shares), nil
+}
+
+// EmbedShares hides files in each of several WAV containers, writing the
+// container at containers[i] to outputs[i]. Every container holds the whole
+// encrypted payload and one share of its key, and any threshold of them
+// recover it. A password in opts is needed as well when set.
+func EmbedShares(inputFiles []string, outputs []string, containers []string, threshold int, opts EmbedOptions) error {
+	if len(containers) != len(outputs) {
+		return errors.New("every container needs an output file")
+	}
+	if err := ValidateThreshold(threshold, len(containers)); err != nil {
+		return err
+	}
+	if len(opts.Recipients) > 0 {
+		return errors.New("key shares cannot be combined with recipients")
+	}
+
+	secret := make([]byte, keySize)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+	values, err := splitSecret(secret, threshold, len(containers))
+	if err != nil {
+		return err
+	}
+
+	// Read Input Files (Data to be embedded) and pack them with their names, modes and mtimes
+	debugf(opts.Logf, "Reading input files for hiding process.")
+	opts.Encryption = true
+	gdp, err := sealGDP(ContentTar, func(w io.Writer) error {
+		return WriteArchive(w, inputFiles)
+	}, opts, secret)
+	if err != nil {
+		return err
+	}
+
+	share := keyShare{threshold: threshold, count: len(containers)}
+	if _, err := rand.Read(share.setID[:]); err != nil {
+		return fmt.Errorf("failed to generate set ID: %w", err)
+	}
+	debugf(opts.Logf, "Sharing the key across %d containers, %d needed, set ID %s",
+		len(containers), threshold, hex.EncodeToString(share.setID[:]))
+
+	// Write every output next to its final name first, and rename them only once all are done
+	temps := make([]string, len(containers))
+	defer func() {
+		for _, temp := range temps {
+			if temp != "" {
+				os.Remove(temp)
+			}
+		}
+	}()
+	for i, container := range containers {
+		share.index, share.value = i+1, values[i]
+		gdp.SetExtension(ExtShare, encodeShare(share))
+		gdpFile, err := MakeGDPFile(*gdp)
+		if err != nil {
+			return err
+		}
+		temps[i], err = embedToTemp(outputs[i], container, gdpFile, 0, nil, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", container, err)
+		}
+	}
+	for i, temp := range temps {
+		if err := os.Rename(temp, outputs[i]); err != nil {
+			return err
+		}
+		temps[i] = ""
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package stego
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestShamirSplitCombine recovers the secret from any threshold of shares and
+// not from fewer.
+func TestShamirSplitCombine(t *testing.T) {
+	secret := randomBytes(keySize, 19)
+	for _, test := range []struct{ threshold, count int }{{2, 2}, {2, 5}, {3, 5}, {5, 5}, {4, 255}} {
+		values, err := splitSecret(secret, test.threshold, test.count)
+		if err != nil {
+			t.Fatalf("%d of %d: %v", test.threshold, test.count, err)
+		}
+		shares := make([]*keyShare, test.count)
+		for i, value := range values {
+			shares[i] = &keyShare{threshold: test.threshold, count: test.count, index: i + 1, value: value}
+		}
+
+		// The first, the last and a spread of shares all work
+		for _, start := range []int{0, test.count - test.threshold, (test.count - test.threshold) / 2} {
+			if got := combineShares(shares[start : start+test.threshold]); !bytes.Equal(got, secret) {
+				t.Fatalf("%d of %d: shares %d-%d recovered the wrong secret", test.threshold, test.count, start+1, start+test.threshold)
+			}
+		}
+		if got := combineShares(shares[:test.threshold-1]); bytes.Equal(got, secret) {
+			t.Fatalf("%d of %d: %d shares recovered the secret", test.threshold, test.count, test.threshold-1)
+		}
+	}
+}
+
+// TestShareField round-trips the ExtShare field and rejects bad ones.
+func TestShareField(t *testing.T) {
+	share := keyShare{setID: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}, threshold: 2, count: 3, index: 3, value: randomBytes(keySize, 20)}
+	parsed, err := parseShare(encodeShare(share))
+	if err != nil {
+		t.Fatalf("parseShare: %v", err)
+	}
+	if parsed.setID != share.setID || parsed.threshold != 2 || parsed.count != 3 || parsed.index != 3 || !bytes.Equal(parsed.value, share.value) {
+		t.Fatalf("share did not round-trip: %+v", parsed)
+	}
+
+	for name, bad := range map[string]keyShare{
+		"index 0":            {threshold: 2, count: 3, index: 0, value: share.value},
+		"index over count":   {threshold: 2, count: 3, index: 4, value: share.value},
+		"threshold 0":        {threshold: 0, count: 3, index: 1, value: share.value},
+		"threshold over all": {threshold: 4, count: 3, index: 1, value: share.value},
+		"short share":        {threshold: 2, count: 3, index: 1, value: share.value[:16]},
+	} {
+		if _, err := parseShare(encodeShare(bad)); err == nil {
+			t.Fatalf("%s: parseShare accepted a bad field", name)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package stego
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// ErrBadSignature means the payload's signature does not verify, or it was
+// not signed by one of the keys extraction was told to trust.
+var ErrBadSignature = errors.New("signature check failed")
+
+// Signature field
+// ---------------------------------------------------------
+// Public key : 32 bytes, the Ed25519 key of the signer
+// Signature  : 64 bytes, Ed25519 over the signed message
+// ---------------------------------------------------------
+//
+// The signed message is a label, the content type, the file name and MIME
+// type fields when present, and the SHA-512 digest of the plaintext. The
+// plaintext of a tar payload holds the names, modes and mtimes of the hidden
+// files, so they are signed as well. The field is not critical: readers that
+// do not know it still open the payload, they just cannot tell who sent it.
+//
+// The public key names the sender, so an encrypted payload does not carry
+// the field in its header. It goes in front of the padded, compressed
+// payload, inside the encryption, and the empty critical ExtSealedSig field
+// says so. Only an unencrypted payload, which hides nothing anyway, is
+// signed in the clear.
+
+const (
+	signerPrefix     = "godeep-sign-pub-"
+	signingKeyPrefix = "GODEEP-SIGN-SECRET-"
+
+	signatureFieldSize = ed25519.PublicKeySize + ed25519.SignatureSize
+	signatureLabel     = "GoDeep Ed25519 signature"
+)
+
+// SigningKey is an Ed25519 private key that signs the payloads it embeds.
+type SigningKey struct {
+	key ed25519.PrivateKey
+}
+
+// VerifyingKey is the Ed25519 public key that checks a SigningKey's signatures.
+type VerifyingKey struct {
+	key ed25519.PublicKey
+}
+
+// GenerateSigningKey creates a new random signing key.
+func GenerateSigningKey() (*SigningKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{key: key}, nil
+}
+
+// Public returns the key that verifies the signing key's signatures.
+func (k *SigningKey) Public() *VerifyingKey {
+	return &VerifyingKey{key: k.key.Public().(ed25519.PublicKey)}
+}
+
+// String encodes the signing key, which must be kept secret.
+func (k *SigningKey) String() string {
+	return signingKeyPrefix + base64.RawURLEncoding.EncodeToString(k.key.Seed())
+}
+
+// String encodes the verifying key as it is shared with others.
+func (k *VerifyingKey) String() string {
+	return signerPrefix + base64.RawURLEncoding.EncodeToString(k.key)
+}
+
+// Equal reports whether both keys are the same.
+func (k *VerifyingKey) Equal(other *VerifyingKey) bool {
+	return k.key.Equal(other.key)
+}
+
+// ParseSigningKey decodes a signing key written by SigningKey.String.
+func ParseSigningKey(s string) (*SigningKey, error) {
+	seed, err := parseKey(s, signingKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	return &SigningKey{key: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+// ParseVerifyingKey decodes a verifying key written by VerifyingKey.String.
+func ParseVerifyingKey(s string) (*VerifyingKey, error) {
+	key, err := parseKey(s, signerPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signer key: %w", err)
+	}
+	return &VerifyingKey{key: ed25519.PublicKey(key)}, nil
+}
+
+// signedMessage builds the message a signature covers from the header fields
+// and the SHA-512 digest of the plaintext.
+func signedMessage(gdp *GDPFile, digest []byte) []byte {
+	message := append([]byte(signatureLabel), gdp.Content)
+	for _, extType := range []uint8{ExtFilename, ExtMIMEType} {
+		if value, ok := gdp.Extension(extType); ok {
+			message = append(message, extType)
+			message = binary.LittleEndian.AppendUint16(message, uint16(len(value)))
+			message = append(message, value...)
+		}
+	}
+	return append(message, digest...)
+}
+
+// sign returns the value of the Signature field for a GDP file whose
+// plaintext has the given SHA-512 digest.
+func (k *SigningKey) sign(gdp *GDPFile, digest []byte) []byte {
+	field := append([]byte(nil), k.key.Public().(ed25519.PublicKey)...)
+	return append(field, ed25519.Sign(k.key, signedMessage(gdp, digest))...)
+}
+
+// checkSignature verifies the Signature field value of an opened payload,
+// nil when it is unsigned, and returns its signer. With signers given, the
+// payload must be signed by one of them.
+func checkSignature(gdp *GDPFile, value, plaintext []byte, signers []*VerifyingKey) (*VerifyingKey, error) {
+	if value == nil {
+		if len(signers) > 0 {
+			return nil, fmt.Errorf("%w: the payload is not signed", ErrBadSignature)
+		}
+		return nil, nil
+	}
+	if len(value) != signatureFieldSize {
+		return nil, errors.New("invalid GDP file: bad signature field")
+	}
+
+	signer := &VerifyingKey{key: ed25519.PublicKey(value[:ed25519.PublicKeySize])}
+	digest := sha512.Sum512(plaintext)
+	if !ed25519.Verify(signer.key, signedMessage(gdp, digest[:]), value[ed25519.PublicKeySize:]) {
+		return nil, fmt.Errorf("%w: the signature of %s does not match the payload", ErrBadSignature, signer)
+	}
+	if len(signers) == 0 {
+		return signer, nil
+	}
+	for _, trusted := range signers {
+		if trusted.Equal(signer) {
+			return signer, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: signed by %s, which is not one of the given signers", ErrBadSignature, signer)
+}
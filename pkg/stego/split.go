@@ -0,0 +1,435 @@
+package stego
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Split sets
+// ---------------------------------------------------------
+// Set ID  : 8 bytes, random, shared by every piece of a set
+// Index   : 2 bytes (uint16), piece number from 0
+// Count   : 2 bytes (uint16), pieces in the set
+// Total   : 8 bytes (uint64), size of the whole GDP file
+// Length  : 4 bytes (uint32), bytes of the GDP file in this piece
+// CRC32   : 4 bytes (uint32), IEEE CRC of the fields above
+// Data    : the next Length bytes of the GDP file
+// ---------------------------------------------------------
+//
+// A GDP file too large for one container is cut into pieces, one per
+// container, in proportion to what each can hold. Each piece is framed as
+// above and embedded with layoutSplit set in the layout byte; error
+// correction and sync blocks apply to each container on its own. Extraction
+// takes the containers in any order and joins the pieces by index. The CRC
+// tells a piece header from LSBs that only happen to carry the split flag.
+
+const pieceHeaderSize = 28
+
+// MaxPieces is the largest number of containers a payload can be split across.
+const MaxPieces = 1<<16 - 1
+
+// piece is one container's share of a split GDP file.
+type piece struct {
+	setID [8]byte
+	index int
+	count int
+	total uint64
+	data  []byte // Bytes of the piece read, which stop early when only the header was
+}
+
+// encodePiece frames one piece of a set.
+func encodePiece(p piece) []byte {
+	out := make([]byte, pieceHeaderSize, pieceHeaderSize+len(p.data))
+	copy(out, p.setID[:])
+	binary.LittleEndian.PutUint16(out[8:], uint16(p.index))
+	binary.LittleEndian.PutUint16(out[10:], uint16(p.count))
+	binary.LittleEndian.PutUint64(out[12:], p.total)
+	binary.LittleEndian.PutUint32(out[20:], uint32(len(p.data)))
+	binary.LittleEndian.PutUint32(out[24:], crc32.ChecksumIEEE(out[:24]))
+	return append(out, p.data...)
+}
+
+// checkPieceHeader verifies the CRC and the fields of a piece header, and
+// returns the length of the piece. A header that fails is taken for no data.
+func checkPieceHeader(data []byte) (int, error) {
+	if binary.LittleEndian.Uint32(data[24:28]) != crc32.ChecksumIEEE(data[:24]) {
+		return 0, fmt.Errorf("%w: bad split piece header", ErrBadMagic)
+	}
+	index := binary.LittleEndian.Uint16(data[8:10])
+	count := binary.LittleEndian.Uint16(data[10:12])
+	total := binary.LittleEndian.Uint64(data[12:20])
+	length := binary.LittleEndian.Uint32(data[20:24])
+	if count == 0 || index >= count || uint64(length) > total {
+		return 0, fmt.Errorf("%w: invalid split piece %d of %d", ErrBadMagic, index+1, count)
+	}
+	return int(length), nil
+}
+
+// pieceFrameSize returns the size of a whole piece or, with headerOnly, of
+// the piece header and, in the first piece, the GDP header that follows it.
+func pieceFrameSize(headerOnly bool) frameSize {
+	return func(data []byte) (int, error) {
+		if len(data) < pieceHeaderSize {
+			return 0, errGDPTruncated
+		}
+		length, err := checkPieceHeader(data)
+		if err != nil {
+			return 0, err
+		}
+		size := pieceHeaderSize + length
+		if !headerOnly {
+			return size, nil
+		}
+		if binary.LittleEndian.Uint16(data[8:10]) != 0 {
+			return pieceHeaderSize, nil
+		}
+		gdp, err := ParseGDPFile(data[pieceHeaderSize:min(len(data), size)], true)
+		switch {
+		case errors.Is(err, errGDPTruncated) && len(data) >= size:
+			// The GDP header runs on into the next piece
+			return size, nil
+		case err != nil:
+			return 0, err
+		}
+		return pieceHeaderSize + gdp.HeaderSize, nil
+	}
+}
+
+// parsePiece reads a framed piece. The data may stop early, when only the
+// header was read, and joinPieces then finds the set too short.
+func parsePiece(data []byte) (*piece, error) {
+	if len(data) < pieceHeaderSize {
+		return nil, fmt.Errorf("%w: split piece header too short", ErrBadMagic)
+	}
+	length, err := checkPieceHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	size := pieceHeaderSize + length
+	p := &piece{
+		index: int(binary.LittleEndian.Uint16(data[8:10])),
+		count: int(binary.LittleEndian.Uint16(data[10:12])),
+		total: binary.LittleEndian.Uint64(data[12:20]),
+		data:  data[pieceHeaderSize:min(size, len(data))],
+	}
+	copy(p.setID[:], data[:8])
+	return p, nil
+}
+
+// joinPieces puts the pieces of a set back together into the GDP file.
+func joinPieces(pieces []*piece) ([]byte, error) {
+	first := pieces[0]
+	byIndex := make([][]byte, first.count)
+	for _, p := range pieces {
+		if p.setID != first.setID || p.count != first.count || p.total != first.total {
+			return nil, fmt.Errorf("the containers belong to different sets (%x and %x)", first.setID, p.setID)
+		}
+		byIndex[p.index] = p.data
+	}
+
+	// The total comes from the carrier, check it before allocating
+	if first.total < uint64(len(first.data)) || first.total > uint64(first.count)*math.MaxUint32 {
+		return nil, fmt.Errorf("invalid split set %x: bad total size %d", first.setID, first.total)
+	}
+	var missing []int
+	var size uint64
+	for index, data := range byIndex {
+		if data == nil {
+			missing = append(missing, index)
+		}
+		size += uint64(len(data))
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: containers %s of %d of set %x are missing",
+			ErrIncomplete, FormatBlocks(missing), first.count, first.setID)
+	}
+	if size != first.total {
+		return nil, fmt.Errorf("invalid split set %x: %d of %d bytes", first.setID, size, first.total)
+	}
+	gdpFile := make([]byte, 0, size)
+	for _, data := range byIndex {
+		gdpFile = append(gdpFile, data...)
+	}
+	return gdpFile, nil
+}
+
+// framedSize returns the bytes a frame takes in the carrier once wrapped in
+// error correction and sync blocks as opts asks. headerSize is the part of
+// the frame sync blocks write twice.
+func framedSize(size int, headerSize int, opts EmbedOptions) int {
+	if opts.FEC != 0 {
+		size, headerSize = FECSize(size, opts.FEC), fecHeaderSize
+	}
+	if opts.Sync {
+		size = SyncSize(size, headerSize)
+	}
+	return size
+}
+
+// frameCapacity returns the largest frame, headerSize bytes of which are its
+// header, that takes at most available bytes once framed as opts asks.
+func frameCapacity(available int, headerSize int, opts EmbedOptions) int {
+	low, high := 0, available
+	for low < high {
+		mid := (low + high + 1) / 2
+		if framedSize(mid, headerSize, opts) <= available {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	if framedSize(low, headerSize, opts) > available {
+		return 0
+	}
+	return low
+}
+
+// pieceCapacity returns the largest share of a GDP file that fits in a
+// container with the given number of samples.
+func pieceCapacity(samples int, depth int, opts EmbedOptions) int {
+	available := max(samples-layoutSize, 0) * depth / 8
+	return max(frameCapacity(available, pieceHeaderSize, opts)-pieceHeaderSize, 0)
+}
+
+// splitSizes shares total bytes between containers in proportion to their
+// capacities, or reports how many more containers would be needed.
+func splitSizes(total int, capacities []int) ([]int, error) {
+	sum := 0
+	for _, capacity := range capacities {
+		sum += capacity
+	}
+	if total > sum {
+		more := "more containers"
+		if last := capacities[len(capacities)-1]; last > 0 {
+			more = fmt.Sprintf("about %d more containers like the last", (total-sum+last-1)/last)
+		}
+		return nil, fmt.Errorf("%w: the payload needs %d bytes, the %d containers hold %d, %s",
+			ErrCapacity, total, len(capacities), sum, more)
+	}
+
+	sizes := make([]int, len(capacities))
+	assigned := 0
+	for i, capacity := range capacities {
+		sizes[i] = int(int64(total) * int64(capacity) / int64(sum))
+		assigned += sizes[i]
+	}
+	// Rounding leaves a few bytes over, hand them to whichever container has room
+	for i := 0; assigned < total; i = (i + 1) % len(sizes) {
+		if sizes[i] < capacities[i] {
+			extra := min(capacities[i]-sizes[i], total-assigned)
+			sizes[i] += extra
+			assigned += extra
+		}
+	}
+	return sizes, nil
+}
+
+// ContainersNeeded returns the smallest number of the given containers that
+// can hold a payload of size bytes, picking the largest first, or 0 when even
+// all of them together are too small.
+func ContainersNeeded(size int, capacities []int) int {
+	sorted := append([]int(nil), capacities...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	sum := 0
+	for i, capacity := range sorted {
+		sum += capacity
+		if sum >= size {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// EmbedSet hides files across several WAV containers, writing the container
+// at containers[i] to outputs[i]. The payload is shared between them in
+// proportion to their capacity, and all of them are needed to extract it.
+// It returns the smallest number of the containers that could hold the
+// payload; when even all of them are too small the ErrCapacity says how many
+// more are needed.
+func EmbedSet(inputFiles []string, outputs []string, containers []string, opts EmbedOptions) (int, error) {
+	if len(containers) != len(outputs) {
+		return 0, errors.New("every container needs an output file")
+	}
+	if len(containers) > MaxPieces {
+		return 0, fmt.Errorf("a payload can be split across at most %d containers", MaxPieces)
+	}
+	depth := opts.Depth
+	if depth == 0 {
+		depth = MinDepth
+	}
+
+	// Read Input Files (Data to be embedded) and pack them with their names, modes and mtimes
+	debugf(opts.Logf, "Reading input files for hiding process.")
+	gdpFile, err := sealPayload(ContentTar, func(w io.Writer) error {
+		return WriteArchive(w, inputFiles)
+	}, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	// Work out what each container holds from its header alone
+	capacities := make([]int, len(containers))
+	for i, container := range containers {
+		file, err := os.Open(container)
+		if err != nil {
+			return 0, err
+		}
+		stream, err := readWAVHeader(file, 0)
+		if err == nil {
+			var samples int64
+			samples, err = fileSamples(file, stream)
+			capacities[i] = pieceCapacity(int(samples), depth, opts)
+		}
+		file.Close()
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", container, err)
+		}
+		debugf(opts.Logf, "Container %s holds %d bytes.", container, capacities[i])
+	}
+	sizes, err := splitSizes(len(gdpFile), capacities)
+	if err != nil {
+		return 0, err
+	}
+	needed := ContainersNeeded(len(gdpFile), capacities)
+	debugf(opts.Logf, "Splitting %d bytes across %d containers, at least %d are needed.", len(gdpFile), len(containers), needed)
+
+	set := piece{count: len(containers), total: uint64(len(gdpFile))}
+	if _, err := rand.Read(set.setID[:]); err != nil {
+		return 0, fmt.Errorf("failed to generate set ID: %w", err)
+	}
+	debugf(opts.Logf, "Set ID: %s", hex.EncodeToString(set.setID[:]))
+
+	// Write every output next to its final name first, and rename them only once all are done
+	temps := make([]string, len(containers))
+	defer func() {
+		for _, temp := range temps {
+			if temp != "" {
+				os.Remove(temp)
+			}
+		}
+	}()
+	offset := 0
+	for i, container := range containers {
+		set.index, set.data = i, gdpFile[offset:offset+sizes[i]]
+		offset += sizes[i]
+		temps[i], err = embedToTemp(outputs[i], container, encodePiece(set), layoutSplit, nil, opts)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", container, err)
+		}
+	}
+	for i, temp := range temps {
+		if err := os.Rename(temp, outputs[i]); err != nil {
+			return 0, err
+		}
+		temps[i] = ""
+	}
+	return needed, nil
+}
+
+// openSet extracts the pieces of a set from its containers, given in any
+// order, joins them and decrypts the payload. Containers that are not part of
+// a split set are opened as they are, together when they hold key shares.
+// The plaintext is returned in Raw.
+func openSet(containers []string, opts ExtractOptions) (*Payload, error) {
+	if len(containers) == 0 {
+		return nil, errors.New("no container given")
+	}
+
+	var pieces []*piece
+	var gdpFiles [][]byte
+	var location gdpLocation
+	corrected := 0
+	for _, container := range containers {
+		debugf(opts.Logf, "Reading container WAV file %s", container)
+		carrier, err := os.Open(container)
+		if err != nil {
+			return nil, err
+		}
+		var data []byte
+		data, location, err = extractPiece(carrier, opts)
+		if errors.Is(err, ErrBadMagic) && len(containers) == 1 {
+			// Without a layout byte the payload may be in stealth mode
+			var payload *Payload
+			payload, err = openStealth(carrier, 0, opts, err)
+			carrier.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", container, err)
+			}
+			return payload, nil
+		}
+		carrier.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", container, err)
+		}
+		corrected += location.corrected
+
+		if !location.split {
+			gdpFiles = append(gdpFiles, data)
+			continue
+		}
+		p, err := parsePiece(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", container, err)
+		}
+		debugf(opts.Logf, "Container %s is piece %d of %d of set %x.", container, p.index+1, p.count, p.setID)
+		pieces = append(pieces, p)
+	}
+	if pieces != nil {
+		if gdpFiles != nil {
+			return nil, errors.New("the containers mix pieces of a split set with whole payloads")
+		}
+		gdpFile, err := joinPieces(pieces)
+		if err != nil {
+			return nil, err
+		}
+		gdpFiles = [][]byte{gdpFile}
+	}
+
+	payload, err := openGDP(gdpFiles, opts)
+	if errors.Is(err, ErrAuthFailed) && len(containers) == 1 && pieces == nil {
+		// The password may be the one of a payload hidden behind this one
+		carrier, openErr := os.Open(containers[0])
+		if openErr != nil {
+			return nil, openErr
+		}
+		defer carrier.Close()
+		return tryHidden(carrier, location, opts, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload.FEC, payload.Corrected = location.fec, corrected
+	if len(pieces) > 0 {
+		payload.Pieces = pieces[0].count
+	}
+	// Sync blocks are counted per container, so they are only reported for one
+	if len(containers) == 1 {
+		payload.Blocks, payload.Missing = location.blocks, location.missing
+	}
+	return payload, nil
+}
+
+// SetOutputs names the outputs for a set of containers: each container's file
+// name inside the output directory.
+func SetOutputs(outputDir string, containers []string) ([]string, error) {
+	outputs := make([]string, len(containers))
+	seen := make(map[string]bool)
+	for i, container := range containers {
+		name := filepath.Base(container)
+		if seen[name] {
+			return nil, fmt.Errorf("two containers are named %s, their outputs would collide", name)
+		}
+		seen[name] = true
+		outputs[i] = filepath.Join(outputDir, name)
+	}
+	return outputs, nil
+}
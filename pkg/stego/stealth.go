@@ -0,0 +1,92 @@
+package stego
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Stealth mode
+// ---------------------------------------------------------
+// A stealth container holds a masked frame, as a hidden payload does, with
+// no decoy and no layout byte in front of it. The frame is scattered over
+// every sample from the first one, so no bit is left in the clear for a
+// scanner to look for. Nothing records the depth either: extraction tries
+// each depth in turn, or only the one it is given, and takes the first one
+// whose header unmasks to a GDP file. The mask key is derived as for a hidden
+// payload, with Argon2id and the salt in front of the frame, so each depth
+// tried reads its own salt and costs its own Argon2id run.
+
+// embedStealth writes a GDP file masked and scattered with keys derived from
+// the password, without a layout byte.
+func embedStealth(output io.WriteSeeker, carrier io.Reader, gdpFile []byte, depth int, opts EmbedOptions) error {
+	if opts.Password == "" {
+		return errors.New("a password is required for stealth mode")
+	}
+	if opts.Scatter || opts.Sync || opts.FEC != 0 {
+		return errors.New("stealth mode cannot be combined with scattered placement, sync blocks or error correction")
+	}
+
+	keys := newHiddenKeys(opts.Password)
+	masked, err := maskFrame(gdpFile, keys)
+	if err != nil {
+		return err
+	}
+	debugf(opts.Logf, "Stealth mode: masking the GDP file and scattering it from the first sample.")
+	return embedStream(output, carrier, nil, byte(depth), nil, &hiddenFrame{data: masked, seed: keys.seed}, opts.Logf)
+}
+
+// findStealth looks for a stealth frame in a carrier read from start, trying
+// every depth unless opts gives one. It returns the unmasked GDP file, or only
+// its header, and the depth it was found at, or ErrBadMagic.
+func findStealth(carrier io.ReadSeeker, start int64, headerOnly bool, opts ExtractOptions) ([]byte, int, error) {
+	if opts.Password == "" {
+		return nil, 0, ErrBadMagic
+	}
+	sampleSize := 0
+	if opts.LegacyLayout {
+		sampleSize = LegacySampleSize
+	}
+	depths := []int{MinDepth, 2, 3, MaxDepth}
+	if opts.Depth != 0 {
+		depths = []int{opts.Depth}
+	}
+
+	keys := newHiddenKeys(opts.Password)
+	for _, depth := range depths {
+		if _, err := carrier.Seek(start, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		stream, err := readWAVHeader(carrier, sampleSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		gdpFile, err := gatherHidden(stream, 0, depth, keys, headerOnly)
+		if err == nil {
+			debugf(opts.Logf, "Found a stealth GDP file of %d bytes at depth %d.", len(gdpFile), depth)
+			return gdpFile, depth, nil
+		}
+		debugf(opts.Logf, "No stealth GDP file at depth %d.", depth)
+	}
+	return nil, 0, fmt.Errorf("%w: no stealth payload opens with this password", ErrBadMagic)
+}
+
+// openStealth opens the stealth payload of a carrier in which extraction
+// found no GDP file, failing with extractErr, which is returned when the
+// password finds none either.
+func openStealth(carrier io.ReadSeeker, start int64, opts ExtractOptions, extractErr error) (*Payload, error) {
+	gdpFile, _, err := findStealth(carrier, start, false, opts)
+	if errors.Is(err, ErrBadMagic) {
+		return nil, extractErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := openGDP([][]byte{gdpFile}, opts)
+	if err != nil {
+		return nil, err
+	}
+	payload.Stealth = true
+	return payload, nil
+}
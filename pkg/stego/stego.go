@@ -0,0 +1,670 @@
+// Package stego hides files in the least significant bits of WAV audio and
+// recovers them. It never prints or exits: every failure is returned as an
+// error, and the sentinel errors below can be matched with errors.Is.
+package stego
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	// ErrCapacity means the payload does not fit in the container.
+	ErrCapacity = errors.New("payload too large for the container")
+
+	// ErrBadMagic means no GoDeep data was found in the container. With
+	// scattered placement this is also the result of a wrong password.
+	ErrBadMagic = errors.New("no embedded data found")
+
+	// ErrAuthFailed means the payload could not be decrypted, because the
+	// password is wrong or missing or the data was modified.
+	ErrAuthFailed = errors.New("authentication failed")
+
+	// ErrUnsupportedFormat means the carrier is not a WAV file that can be
+	// used, or the GDP file needs a version or feature this package lacks.
+	ErrUnsupportedFormat = errors.New("unsupported format")
+)
+
+// EmbedOptions controls how Embed hides files.
+type EmbedOptions struct {
+	Password   string
+	Encryption bool
+	KDF        KDFParams // Key derivation for encrypted payloads, a fresh salt is added; zero uses DefaultKDFParams
+	Cipher     uint8     // Cipher for encrypted payloads, CipherAESGCM (zero) or CipherXChaCha20
+	Depth      int       // LSBs used per sample (1-4), 0 uses MinDepth
+	Scatter    bool      // Place the data at password-derived sample positions instead of in order
+	Checksum   uint8     // Digest of the plaintext stored in the header, ChecksumNone (zero) stores none
+	FEC        int       // Reed–Solomon parity bytes per 255-byte block (2-MaxFEC), 0 disables error correction
+	Sync       bool      // Write sync blocks that can be found again in a trimmed carrier
+	Stealth    bool      // Mask the whole GDP file and leave out the layout byte, so nothing is in the clear
+
+	// Recipients the payload is encrypted to, with a random key instead of
+	// the password's; with a password as well, both are needed.
+	Recipients []*Recipient
+
+	// SignKey, when set, signs the plaintext and its metadata so extraction
+	// can tell who embedded it.
+	SignKey *SigningKey
+
+	// Filename and MIMEType describe the data given to EmbedStream, which
+	// has no file metadata of its own. They are stored in the clear header,
+	// so leave them empty to keep them private. Embed stores file names
+	// inside the payload and ignores them.
+	Filename string
+	MIMEType string
+
+	// Padding rounds the payload up to a multiple of this many bytes before
+	// it is encrypted, or with PadFill makes it fill the container, so its
+	// size does not give away the size of the hidden files. 0 does not pad.
+	Padding int
+	fill    int // Bytes the frame may take in the container, set by Embed for PadFill
+
+	// Logf receives progress messages when set.
+	Logf func(format string, args ...any)
+}
+
+// ExtractOptions controls how Open and Extract recover files.
+type ExtractOptions struct {
+	Password     string
+	Depth        int         // LSBs used per sample, 0 reads the depth recorded in the container
+	LegacyLayout bool        // Read containers written with the old layout, which used every PCM byte
+	NoPreserve   bool        // Do not restore the hidden files' permission bits and modification times
+	Identities   []*Identity // Private keys tried on payloads encrypted to recipients
+
+	// Signers, when given, are the only keys a payload may be signed by, and
+	// unsigned payloads are refused.
+	Signers []*VerifyingKey
+
+	// Logf receives progress messages when set.
+	Logf func(format string, args ...any)
+}
+
+// Payload is the decrypted content of a container.
+type Payload struct {
+	Header  *GDPFile
+	Entries []FileEntry // Hidden files and directories, nil for a raw payload
+	Raw     []byte      // Plaintext of a payload stored without file metadata
+
+	FEC       int // Parity bytes per error correction block, 0 without error correction
+	Corrected int // Damaged bytes repaired by error correction
+
+	Blocks  int   // Sync blocks the data was written in, 0 without sync blocks
+	Missing []int // Zero-based numbers of the sync blocks not found, filled in by error correction
+
+	Pieces int // Containers the payload was split across, 0 when it was not split
+	Shares int // Key shares combined to decrypt the payload, 0 when its key was not shared
+
+	Signer  *VerifyingKey // Key whose signature was verified, nil for an unsigned payload
+	Hidden  bool          // The payload was hidden behind a decoy and opened with its own password
+	Stealth bool          // The payload was stored in stealth mode and found by trial decryption
+}
+
+// debugf forwards a progress message to an optional logger.
+func debugf(logf func(string, ...any), format string, args ...any) {
+	if logf != nil {
+		logf(format, args...)
+	}
+}
+
+// Embed hides files and directories inside a WAV container.
+func Embed(inputFiles []string, outputFile string, container string, opts EmbedOptions) error {
+	if opts.Padding == PadFill {
+		var err error
+		if opts.fill, err = fillCapacity(container, opts); err != nil {
+			return err
+		}
+		debugf(opts.Logf, "Padding the payload to fill %d bytes of the container.", opts.fill)
+	}
+
+	// Read Input Files (Data to be embedded) and pack them with their names, modes and mtimes
+	debugf(opts.Logf, "Reading input files for hiding process.")
+	gdpFile, err := sealPayload(ContentTar, func(w io.Writer) error {
+		return WriteArchive(w, inputFiles)
+	}, opts)
+	if err != nil {
+		return err
+	}
+
+	temp, err := embedToTemp(outputFile, container, gdpFile, 0, nil, opts)
+	if temp != "" {
+		defer os.Remove(temp)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp, outputFile)
+}
+
+// embedToTemp hides a frame, and a hidden one when given, in a container and
+// writes the result to a temporary file next to outputFile, returning its
+// name. Renaming it once everything succeeded means a failure leaves no
+// partial file behind.
+func embedToTemp(outputFile string, container string, frame []byte, flags byte, hidden *hiddenFrame, opts EmbedOptions) (string, error) {
+	carrier, err := os.Open(container)
+	if err != nil {
+		return "", err
+	}
+	defer carrier.Close()
+
+	output, err := os.CreateTemp(filepath.Dir(outputFile), ".godeep-*.wav")
+	if err != nil {
+		return "", err
+	}
+	defer output.Close()
+
+	if err := embedGDP(output, carrier, frame, flags, hidden, opts); err != nil {
+		return output.Name(), err
+	}
+	if err := output.Chmod(0644); err != nil {
+		return output.Name(), err
+	}
+	return output.Name(), output.Close()
+}
+
+// EmbedStream hides the data read from payload in the WAV carrier and writes
+// the result to output, processing the carrier in blocks of BlockSize. The
+// payload is stored without file metadata, apart from the name and MIME type
+// opts gives. It is compressed into memory as it is read, so the compressed
+// payload must fit in memory although the carrier need not.
+func EmbedStream(output io.WriteSeeker, carrier io.Reader, payload io.Reader, opts EmbedOptions) error {
+	gdpFile, err := sealPayload(ContentRaw, func(w io.Writer) error {
+		_, err := io.Copy(w, payload)
+		return err
+	}, opts)
+	if err != nil {
+		return err
+	}
+	return embedGDP(output, carrier, gdpFile, 0, nil, opts)
+}
+
+// sealPayload compresses and optionally encrypts what write produces, and wraps it in a GDP file.
+func sealPayload(content uint8, write func(io.Writer) error, opts EmbedOptions) ([]byte, error) {
+	gdp, err := sealGDP(content, write, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	gdpFile, err := MakeGDPFile(*gdp)
+	if err != nil {
+		return nil, err
+	}
+	debugf(opts.Logf, "GDP file size: %d bytes", len(gdpFile))
+
+	return gdpFile, nil
+}
+
+// sealGDP compresses and optionally encrypts what write produces into the
+// fields of a GDP file. A secret, when given, is the key shared across
+// containers; it is combined with the password's key if there is a password.
+func sealGDP(content uint8, write func(io.Writer) error, opts EmbedOptions, secret []byte) (*GDPFile, error) {
+	var kdf KDFParams
+	var nonce []byte
+	var extensions []GDPExtension
+	logf := opts.Logf
+
+	// Encrypt a random file key to each recipient
+	if len(opts.Recipients) > 0 {
+		if secret != nil {
+			return nil, errors.New("key shares cannot be combined with recipients")
+		}
+		secret = make([]byte, keySize)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate key: %w", err)
+		}
+		for _, recipient := range opts.Recipients {
+			stanza, err := encryptToRecipient(secret, recipient)
+			if err != nil {
+				return nil, err
+			}
+			extensions = append(extensions, GDPExtension{Type: ExtRecipient, Value: stanza})
+		}
+		opts.Encryption = true
+		debugf(logf, "Encrypting to %d recipients.", len(opts.Recipients))
+	}
+
+	if opts.Encryption && opts.Password == "" && secret == nil {
+		return nil, errors.New("a password is required for encryption")
+	}
+
+	var key []byte
+	if opts.Encryption && opts.Password != "" {
+		// Derive the key with a salt unique to this container
+		kdf = opts.KDF
+		if kdf.Algorithm == KDFNone {
+			kdf = DefaultKDFParams()
+		}
+		var err error
+		kdf.Salt, err = GenerateSalt()
+		if err != nil {
+			return nil, err
+		}
+		debugf(logf, "Deriving key (KDF %s, time %d, memory %d KiB, threads %d).",
+			KDFName(kdf.Algorithm), kdf.Time, kdf.Memory, kdf.Threads)
+		key, err = DeriveKeyWithParams(opts.Password, kdf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if secret != nil {
+		key = shareKey(key, secret)
+	}
+
+	// Compress while the plaintext is produced, so only the compressed form is held
+	var compressed bytes.Buffer
+	xzWriter, err := xz.NewWriter(&compressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create XZ writer: %w", err)
+	}
+	writers := []io.Writer{xzWriter}
+	var checksum hash.Hash
+	if opts.Checksum != ChecksumNone {
+		checksum, err = newChecksum(opts.Checksum, key)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, checksum)
+	}
+	signDigest := sha512.New()
+	if opts.SignKey != nil {
+		writers = append(writers, signDigest)
+	}
+	if err := write(io.MultiWriter(writers...)); err != nil {
+		return nil, err
+	}
+	if err := xzWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close XZ writer: %w", err)
+	}
+	ciphertext := compressed.Bytes()
+
+	if checksum != nil {
+		digest := checksum.Sum([]byte{opts.Checksum})
+		extensions = append(extensions, GDPExtension{Type: ExtChecksum, Value: digest})
+		debugf(logf, "Plaintext %s: %s", ChecksumName(opts.Checksum), hex.EncodeToString(digest[1:]))
+	}
+
+	if opts.Padding != 0 {
+		extensions = append(extensions, GDPExtension{Type: ExtPadding})
+	}
+	if content == ContentRaw && opts.Filename != "" {
+		extensions = append(extensions, GDPExtension{Type: ExtFilename, Value: []byte(opts.Filename)})
+	}
+	if content == ContentRaw && opts.MIMEType != "" {
+		extensions = append(extensions, GDPExtension{Type: ExtMIMEType, Value: []byte(opts.MIMEType)})
+	}
+
+	// Create GDP File Structure, the ciphertext is added once it is padded and encrypted
+	gdp := &GDPFile{
+		Encryption:  opts.Encryption,
+		KDF:         kdf,
+		Cipher:      opts.Cipher,
+		Compression: CompressionXZ,
+		Content:     content,
+		Extensions:  extensions,
+	}
+
+	// Sign the plaintext and the metadata in the header, keeping the signer
+	// inside the encryption when there is one
+	var sealedSignature []byte
+	if opts.SignKey != nil {
+		signature := opts.SignKey.sign(gdp, signDigest.Sum(nil))
+		if opts.Encryption {
+			sealedSignature = signature
+			gdp.SetExtension(ExtSealedSig, nil)
+		} else {
+			gdp.SetExtension(ExtSignature, signature)
+		}
+		debugf(logf, "Signed by %s", opts.SignKey.Public())
+	}
+
+	// Pad the compressed payload inside the encryption, so its length is hidden
+	if opts.Padding != 0 {
+		compressedSize := len(ciphertext)
+		ciphertext, err = padPayload(ciphertext, gdp, opts)
+		if err != nil {
+			return nil, err
+		}
+		debugf(logf, "Padded the compressed payload from %d to %d bytes.", compressedSize, len(ciphertext))
+	}
+	if sealedSignature != nil {
+		ciphertext = append(sealedSignature, ciphertext...)
+	}
+
+	if opts.Encryption {
+		// Encrypt the compressed payload
+		debugf(logf, "Encrypting with %s.", CipherName(opts.Cipher))
+		ciphertext, nonce, err = Encrypt(ciphertext, key, opts.Cipher)
+		if err != nil {
+			return nil, fmt.Errorf("encryption failed: %w", err)
+		}
+	}
+
+	debugf(logf, "Ciphertext length: %d bytes", len(ciphertext))
+	if nonce != nil {
+		debugf(logf, "Nonce (hex): %s", hex.EncodeToString(nonce))
+	}
+	gdp.Nonce, gdp.Ciphertext = nonce, ciphertext
+	return gdp, nil
+}
+
+// embedGDP hides a GDP file, or a piece of one when flags has layoutSplit, in
+// the carrier with the placement chosen in opts. A hidden frame is written at
+// its own positions after it.
+func embedGDP(output io.WriteSeeker, carrier io.Reader, gdpFile []byte, flags byte, hidden *hiddenFrame, opts EmbedOptions) error {
+	depth := opts.Depth
+	if depth == 0 {
+		depth = MinDepth
+	}
+	if err := ValidateDepth(depth); err != nil {
+		return err
+	}
+
+	if hidden != nil && (opts.Scatter || opts.Sync) {
+		return errors.New("a hidden payload needs the decoy written in order, without --scatter or --sync")
+	}
+	if opts.Stealth {
+		if flags != 0 || hidden != nil {
+			return errors.New("stealth mode cannot be combined with split sets or hidden payloads")
+		}
+		return embedStealth(output, carrier, gdpFile, depth, opts)
+	}
+
+	var placementSeed []byte
+	if opts.Scatter {
+		if opts.Sync {
+			return errors.New("sync blocks cannot be scattered, their positions would not survive trimming")
+		}
+		if opts.Password == "" {
+			return errors.New("a password is required for scattered placement")
+		}
+		placementSeed = DerivePlacementSeed(DeriveKey(opts.Password))
+		debugf(opts.Logf, "Scattering data across the container.")
+	}
+
+	// Wrap the GDP file in an error-correcting frame when asked to
+	layout := byte(depth) | flags
+	if opts.FEC != 0 {
+		if err := ValidateFEC(opts.FEC); err != nil {
+			return err
+		}
+		gdpFile = encodeFEC(gdpFile, opts.FEC)
+		layout |= layoutFEC
+		debugf(opts.Logf, "Error correction: %d parity bytes per block, %d bytes with parity.", opts.FEC, len(gdpFile))
+	}
+
+	// Cut the data into sync blocks, repeating the ones holding the header
+	if opts.Sync {
+		headerSize := fecHeaderSize
+		if opts.FEC == 0 {
+			size := gdpFrameSize(true)
+			if flags&layoutSplit != 0 {
+				size = pieceFrameSize(true)
+			}
+			var err error
+			if headerSize, err = size(gdpFile); err != nil {
+				return err
+			}
+		}
+		gdpFile = encodeSync(gdpFile, layout|layoutSync, headerSize)
+		layout |= layoutSync
+		debugf(opts.Logf, "Sync blocks: %d, %d bytes with markers.", syncBlocks(len(gdpFile)), len(gdpFile))
+	}
+
+	// Embed GDP file into container WAV file using LSB encoding
+	return embedStream(output, carrier, gdpFile, layout, placementSeed, hidden, opts.Logf)
+}
+
+// Open reads and decrypts the payload hidden in a WAV container without writing anything.
+func Open(container string, opts ExtractOptions) (*Payload, error) {
+	return OpenSet([]string{container}, opts)
+}
+
+// OpenSet reads and decrypts a payload hidden in one container or split
+// across several, given in any order, without writing anything.
+func OpenSet(containers []string, opts ExtractOptions) (*Payload, error) {
+	payload, err := openSet(containers, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files without metadata are handed back as they are
+	if payload.Header.Content != ContentTar {
+		return payload, nil
+	}
+
+	payload.Entries, err = UnpackArchive(payload.Raw)
+	if err != nil {
+		return nil, err
+	}
+	payload.Raw = nil
+	for _, entry := range payload.Entries {
+		debugf(opts.Logf, "Hidden file: %s, %d bytes, mode %v, modified %s",
+			entry.Name, entry.Size, entry.Mode, entry.ModTime.Format(time.RFC3339))
+	}
+
+	return payload, nil
+}
+
+// ExtractStream reads the WAV carrier in blocks of BlockSize and writes the
+// hidden plaintext to w: data hidden with EmbedStream as it was given, files
+// as the tar archive UnpackArchive reads. Sequential containers are read in a
+// single pass; scattered ones need a carrier that is also an io.Seeker. Only
+// the carrier is read in blocks: the GDP file and the plaintext are held in
+// memory, and nothing is written to w until the payload has been
+// authenticated and decompressed in full.
+func ExtractStream(w io.Writer, carrier io.Reader, opts ExtractOptions) (*GDPFile, error) {
+	payload, err := openPayload(carrier, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(payload.Raw); err != nil {
+		return nil, err
+	}
+	return payload.Header, nil
+}
+
+// openPayload extracts the GDP file from a carrier, then decrypts and
+// decompresses it. The plaintext is returned in Raw. A piece of a split set
+// can only be opened this way when the set has a single piece.
+func openPayload(carrier io.Reader, opts ExtractOptions) (*Payload, error) {
+	seeker, seekable := carrier.(io.Seeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	gdpFile, location, err := extractPiece(carrier, opts)
+	if err != nil {
+		if seekable && errors.Is(err, ErrBadMagic) {
+			// Without a layout byte the payload may be in stealth mode
+			return openStealth(carrier.(io.ReadSeeker), start, opts, err)
+		}
+		return nil, err
+	}
+	if location.split {
+		p, err := parsePiece(gdpFile)
+		if err != nil {
+			return nil, err
+		}
+		if gdpFile, err = joinPieces([]*piece{p}); err != nil {
+			return nil, err
+		}
+	}
+
+	payload, err := openGDP([][]byte{gdpFile}, opts)
+	if errors.Is(err, ErrAuthFailed) && seekable {
+		// The password may be the one of a payload hidden behind this one
+		if _, seekErr := seeker.Seek(start, io.SeekStart); seekErr != nil {
+			return nil, err
+		}
+		return tryHidden(carrier, location, opts, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	payload.FEC, payload.Corrected = location.fec, location.corrected
+	payload.Blocks, payload.Missing = location.blocks, location.missing
+	return payload, nil
+}
+
+// extractPiece reads the GDP file, or the piece of one, hidden in a carrier.
+func extractPiece(carrier io.Reader, opts ExtractOptions) ([]byte, gdpLocation, error) {
+	logf := opts.Logf
+
+	// Extract GDP file from LSB of container WAV file
+	sampleSize := 0
+	if opts.LegacyLayout {
+		sampleSize = LegacySampleSize
+		debugf(logf, "Using legacy byte layout.")
+	}
+	var placementSeed []byte
+	if opts.Password != "" {
+		placementSeed = DerivePlacementSeed(DeriveKey(opts.Password))
+	}
+	stream, err := readWAVHeader(carrier, sampleSize)
+	if err != nil {
+		return nil, gdpLocation{}, err
+	}
+	data, location, err := extractGDP(stream, opts.Depth, placementSeed, false, logf)
+	if err != nil {
+		return nil, location, err
+	}
+	debugf(logf, "Extracted GDP file size: %d bytes", len(data))
+	return data, location, nil
+}
+
+// openGDP decrypts and decompresses a GDP file and checks its checksum. More
+// than one GDP file can only be given when they are copies holding shares of
+// the key; the first one is decrypted.
+func openGDP(gdpFiles [][]byte, opts ExtractOptions) (*Payload, error) {
+	logf := opts.Logf
+
+	// Parse the GDP file to get encryption flag, KDF parameters, nonce, and ciphertext
+	gdp, err := ParseGDPFile(gdpFiles[0], false)
+	if err != nil {
+		return nil, err
+	}
+	debugf(logf, "GDP version: %d, compression: %d, extensions: %d", gdp.Version, gdp.Compression, len(gdp.Extensions))
+
+	var secret []byte
+	shares := 0
+	if _, shared := gdp.Extension(ExtShare); shared {
+		secret, shares, err = recoverSecret(gdpFiles)
+		if err != nil {
+			return nil, err
+		}
+		debugf(logf, "Recovered the key from %d key shares.", shares)
+	} else if len(gdpFiles) > 1 {
+		return nil, errors.New("the containers hold separate payloads, not a split set or key shares")
+	}
+	if stanzas := gdp.ExtensionValues(ExtRecipient); len(stanzas) > 0 {
+		secret, err = decryptStanzas(stanzas, opts.Identities)
+		if err != nil {
+			return nil, err
+		}
+		debugf(logf, "Opened the file key with an identity, %d recipients.", len(stanzas))
+	}
+
+	plaintext := gdp.Ciphertext
+	var key []byte
+	if gdp.Encryption {
+		if gdp.KDF.Algorithm != KDFNone {
+			if opts.Password == "" {
+				return nil, fmt.Errorf("%w: the payload is encrypted, a password is required", ErrAuthFailed)
+			}
+
+			// Derive the key with the salt and cost stored in the header
+			kdf := gdp.KDF
+			debugf(logf, "Deriving key (KDF %s, time %d, memory %d KiB, threads %d).",
+				KDFName(kdf.Algorithm), kdf.Time, kdf.Memory, kdf.Threads)
+			key, err = DeriveKeyWithParams(opts.Password, kdf)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if secret != nil {
+			key = shareKey(key, secret)
+		}
+		debugf(logf, "Decrypting with %s.", CipherName(gdp.Cipher))
+		plaintext, err = Decrypt(gdp.Ciphertext, gdp.Nonce, key, gdp.Cipher)
+		if err != nil {
+			return nil, err
+		}
+	}
+	signature, _ := gdp.Extension(ExtSignature)
+	if _, sealed := gdp.Extension(ExtSealedSig); sealed {
+		if len(plaintext) < signatureFieldSize {
+			return nil, errors.New("invalid GDP file: sealed signature too short")
+		}
+		signature, plaintext = plaintext[:signatureFieldSize], plaintext[signatureFieldSize:]
+	}
+	if _, padded := gdp.Extension(ExtPadding); padded {
+		if plaintext, err = unpadPayload(plaintext); err != nil {
+			return nil, err
+		}
+		debugf(logf, "Removed padding, compressed payload size: %d bytes", len(plaintext))
+	}
+	plaintext, err = Decompress(plaintext, gdp.Compression)
+	if err != nil {
+		return nil, fmt.Errorf("decompression failed: %w", err)
+	}
+	debugf(logf, "Decrypted plaintext size: %d bytes", len(plaintext))
+
+	// Damage to an unencrypted payload is only caught by its checksum
+	if value, ok := gdp.Extension(ExtChecksum); ok {
+		if err := checkChecksum(value, plaintext, key); err != nil {
+			return nil, err
+		}
+		debugf(logf, "Checksum %s verified.", ChecksumName(value[0]))
+	}
+
+	signer, err := checkSignature(gdp, signature, plaintext, opts.Signers)
+	if err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		debugf(logf, "Signature of %s verified.", signer)
+	}
+
+	return &Payload{Header: gdp, Raw: plaintext, Shares: shares, Signer: signer}, nil
+}
+
+// Extract retrieves the hidden files from a WAV container and returns the path
+// they were written to: the file itself, or the directory holding a tree.
+func Extract(container, outputFile string, opts ExtractOptions) (string, error) {
+	payload, err := Open(container, opts)
+	if err != nil {
+		return "", err
+	}
+	return WritePayload(payload, outputFile, !opts.NoPreserve)
+}
+
+// WritePayload writes the files of an opened payload and returns the path
+// they were written to, as Extract does.
+func WritePayload(payload *Payload, outputFile string, preserve bool) (string, error) {
+	// Write to Output File, a lone file may be renamed while a tree needs a directory
+	entries := payload.Entries
+	switch {
+	case entries == nil:
+		if info, err := os.Stat(outputFile); err == nil && info.IsDir() {
+			return "", errors.New("the container holds no file name, give an output file instead of a directory")
+		}
+		return outputFile, os.WriteFile(outputFile, payload.Raw, 0644)
+	case len(entries) == 1 && !entries[0].Mode.IsDir():
+		return WriteFileEntry(&entries[0], outputFile, preserve)
+	default:
+		return outputFile, WriteFileTree(entries, outputFile, preserve)
+	}
+}
@@ -0,0 +1,646 @@
+package stego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Streaming
+// ---------------------------------------------------------
+// The carrier is read chunk by chunk. Everything in front of the sample data
+// is copied as it is, the sample data passes through in blocks of BlockSize
+// bytes, and whatever follows it is copied again. Only the GDP file and, for
+// scattered placement, the sample positions of its bits are held in memory,
+// so memory use follows the payload rather than the carrier.
+//
+// Sequential containers are read in a single pass, so extraction works on a
+// pipe. The samples holding a scattered GDP file depend on its size, which is
+// only known once the header has been read, so scattered extraction passes
+// over the data more than once and needs a reader that can seek.
+//
+// A data chunk size of 0xFFFFFFFF marks a WAV written to a pipe by a program
+// that could not know its length. Its samples run to the end of the stream,
+// and the real sizes are written into the output once embedding is done.
+
+// BlockSize is the amount of sample data processed at a time, rounded down to whole frames.
+const BlockSize = 1 << 20
+
+// riffSizeUnknown is the chunk size written by programs streaming a WAV of unknown length.
+const riffSizeUnknown = 0xFFFFFFFF
+
+// wavStream is a WAV carrier read front to back.
+type wavStream struct {
+	r          io.Reader
+	metadata   *wavMetadata
+	header     []byte // Every byte in front of the sample data
+	sampleSize int
+	dataSize   int64 // Size of the data chunk, -1 when unknown
+	dataStart  int64 // Offset of the sample data in a seekable reader, -1 otherwise
+	pos        int64 // Sample data bytes read so far
+	buf        []byte
+}
+
+// readWAVHeader reads a WAV carrier up to the start of its sample data.
+// A sampleSize of 0 uses the size of the samples described by the fmt chunk.
+func readWAVHeader(r io.Reader, sampleSize int) (*wavStream, error) {
+	var header bytes.Buffer
+	if _, err := io.CopyN(&header, r, 12); err != nil {
+		return nil, fmt.Errorf("%w: not a RIFF WAVE file", ErrUnsupportedFormat)
+	}
+	if string(header.Bytes()[0:4]) != "RIFF" || string(header.Bytes()[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("%w: not a RIFF WAVE file", ErrUnsupportedFormat)
+	}
+
+	var metadata *wavMetadata
+	var dataSize uint32
+	for {
+		start := header.Len()
+		if _, err := io.CopyN(&header, r, 8); err != nil {
+			return nil, fmt.Errorf("%w: missing data chunk", ErrUnsupportedFormat)
+		}
+		id := string(header.Bytes()[start : start+4])
+		size := binary.LittleEndian.Uint32(header.Bytes()[start+4 : start+8])
+		if id == "data" {
+			dataSize = size
+			break
+		}
+
+		// Chunks are padded to an even size
+		if _, err := io.CopyN(&header, r, int64(size)+int64(size%2)); err != nil {
+			return nil, fmt.Errorf("%w: truncated %q chunk", ErrUnsupportedFormat, id)
+		}
+		if id == "fmt " {
+			var err error
+			metadata, err = parseFmtChunk(header.Bytes()[start+8 : start+8+int(size)])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if metadata == nil {
+		return nil, fmt.Errorf("%w: missing fmt chunk before the data chunk", ErrUnsupportedFormat)
+	}
+
+	if sampleSize == 0 {
+		sampleSize = metadata.SampleSize()
+	}
+	stream := &wavStream{
+		r:          r,
+		metadata:   metadata,
+		header:     header.Bytes(),
+		sampleSize: sampleSize,
+		dataSize:   int64(dataSize),
+		dataStart:  -1,
+	}
+	if dataSize == riffSizeUnknown {
+		stream.dataSize = -1
+	}
+	if seeker, ok := r.(io.Seeker); ok {
+		if offset, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			stream.dataStart = offset
+		}
+	}
+
+	blockAlign := int(metadata.BlockAlign)
+	stream.buf = make([]byte, max(BlockSize-BlockSize%blockAlign, blockAlign))
+
+	return stream, nil
+}
+
+// samples returns the number of whole samples in the data chunk, or -1 when its size is unknown.
+func (s *wavStream) samples() int {
+	if s.dataSize < 0 {
+		return -1
+	}
+	frames := s.dataSize - s.dataSize%int64(s.metadata.BlockAlign)
+	return int(frames / int64(s.sampleSize))
+}
+
+// nextBlock reads the next block of sample data. It returns the block, the
+// index of its first sample and the number of whole samples it carries, which
+// leaves out a trailing partial frame. It returns io.EOF after the last block.
+func (s *wavStream) nextBlock() ([]byte, int, int, error) {
+	size := int64(len(s.buf))
+	if s.dataSize >= 0 {
+		size = min(size, s.dataSize-s.pos)
+	}
+	if size == 0 {
+		return nil, 0, 0, io.EOF
+	}
+
+	n, err := io.ReadFull(s.r, s.buf[:size])
+	if err != nil && (s.dataSize >= 0 || n == 0) {
+		if s.dataSize < 0 && err == io.EOF {
+			return nil, 0, 0, io.EOF
+		}
+		return nil, 0, 0, fmt.Errorf("%w: truncated data chunk", ErrUnsupportedFormat)
+	}
+
+	block := s.buf[:n]
+	first := int(s.pos) / s.sampleSize
+	usable := int64(n)
+	if s.dataSize >= 0 {
+		frames := s.dataSize - s.dataSize%int64(s.metadata.BlockAlign)
+		usable = max(min(usable, frames-s.pos), 0)
+	} else {
+		usable -= usable % int64(s.metadata.BlockAlign)
+	}
+	s.pos += int64(n)
+
+	return block, first, int(usable) / s.sampleSize, nil
+}
+
+// rewind moves back to the first sample so the data can be read again.
+func (s *wavStream) rewind() error {
+	seeker, ok := s.r.(io.Seeker)
+	if !ok || s.dataStart < 0 {
+		return errors.New("scattered placement needs a seekable carrier")
+	}
+	if _, err := seeker.Seek(s.dataStart, io.SeekStart); err != nil {
+		return err
+	}
+	s.pos = 0
+	return nil
+}
+
+// slotSample pairs a slot with the sample that carries it.
+type slotSample struct {
+	slot, sample int
+}
+
+// slotSchedule hands out the slots carried by consecutive blocks of samples.
+type slotSchedule struct {
+	offset int
+	count  int
+	sorted []slotSample // Scattered placement in sample order, nil for sequential placement
+	next   int
+}
+
+// newSlotSchedule schedules count slots from offset on, in order or scattered with the given seed.
+func newSlotSchedule(offset int, count int, seed []byte, samples int) *slotSchedule {
+	schedule := &slotSchedule{offset: offset, count: count}
+	if seed != nil {
+		positions := scatteredPlacement(seed, offset, samples, count)
+		schedule.sorted = make([]slotSample, count)
+		for slot := range count {
+			schedule.sorted[slot] = slotSample{slot, positions(slot)}
+		}
+		sort.Slice(schedule.sorted, func(i, j int) bool {
+			return schedule.sorted[i].sample < schedule.sorted[j].sample
+		})
+	}
+	return schedule
+}
+
+// visit calls fn for every slot below limit carried by samples before end,
+// continuing where the previous call stopped. Blocks must be visited in order.
+func (s *slotSchedule) visit(end int, limit int, fn func(slot, sample int)) {
+	limit = min(limit, s.count)
+	if s.sorted != nil {
+		for s.next < limit && s.sorted[s.next].sample < end {
+			fn(s.sorted[s.next].slot, s.sorted[s.next].sample)
+			s.next++
+		}
+		return
+	}
+	for s.next < limit && s.offset+s.next < end {
+		fn(s.next, s.offset+s.next)
+		s.next++
+	}
+}
+
+// slotBits returns the depth bits of data carried by a slot.
+func slotBits(data []byte, slot int, depth int) byte {
+	var bits byte
+	for bit := 0; bit < depth && slot*depth+bit < len(data)*8; bit++ {
+		i := slot*depth + bit
+		bits |= ((data[i/8] >> (i % 8)) & 0x01) << bit
+	}
+	return bits
+}
+
+// putSlotBits stores the depth bits carried by a slot into zeroed data.
+func putSlotBits(data []byte, slot int, depth int, bits byte) {
+	for bit := 0; bit < depth && slot*depth+bit < len(data)*8; bit++ {
+		i := slot*depth + bit
+		data[i/8] |= ((bits >> bit) & 0x01) << (i % 8)
+	}
+}
+
+// embedStream writes the carrier to output with the GDP file, or the frame
+// wrapping it, hidden in its samples. The layout byte gives the depth and
+// flags. A hidden frame, when given, is scattered over the samples after it.
+// Without a GDP file there is no layout byte either, and the hidden frame is
+// scattered from the first sample.
+func embedStream(output io.WriteSeeker, carrier io.Reader, gdpFile []byte, layout byte, placementSeed []byte, hidden *hiddenFrame, logf func(string, ...any)) error {
+	depth := int(layout & layoutDepthMask)
+	if err := ValidateDepth(depth); err != nil {
+		return err
+	}
+
+	stream, err := readWAVHeader(carrier, 0)
+	if err != nil {
+		return err
+	}
+	metadata := stream.metadata
+	debugf(logf, "Container format: %d, %d-bit, %d channels, %d Hz",
+		metadata.AudioFormat, metadata.BitDepth, metadata.NumChans, metadata.SampleRate)
+
+	count := slotCount(len(gdpFile), depth)
+	start := layoutSize + count
+	if gdpFile == nil {
+		start = 0
+	}
+	hiddenCount := 0
+	if hidden != nil {
+		hiddenCount = slotCount(len(hidden.data), depth)
+	}
+	samples := stream.samples()
+	if samples >= 0 {
+		debugf(logf, "Container capacity: %d samples (%d bytes at depth %d)",
+			samples, max(samples-layoutSize, 0)*depth/8, depth)
+		if start+hiddenCount > samples {
+			return fmt.Errorf("%w: needs %d samples, container has %d", ErrCapacity, start+hiddenCount, samples)
+		}
+	} else if placementSeed != nil || hidden != nil {
+		return errors.New("scattered placement needs a container of known length")
+	}
+
+	// Record the layout at one bit per sample so extraction can find it
+	if placementSeed != nil {
+		layout |= layoutScattered
+	}
+	layoutCount := layoutSize
+	if gdpFile == nil {
+		layoutCount = 0
+	}
+	layoutSlots := newSlotSchedule(0, layoutCount, nil, 0)
+	dataSlots := newSlotSchedule(layoutSize, count, placementSeed, samples)
+	hiddenSlots := newSlotSchedule(start, hiddenCount, nil, 0)
+	if hidden != nil {
+		hiddenSlots = newSlotSchedule(start, hiddenCount, hidden.seed, samples)
+	}
+
+	if _, err := output.Write(stream.header); err != nil {
+		return err
+	}
+
+	mask := byte(1)<<depth - 1
+	written := int64(0)
+	for {
+		block, first, n, err := stream.nextBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		// Samples are little-endian, so the LSBs live in the first byte
+		layoutSlots.visit(first+n, layoutCount, func(slot, sample int) {
+			index := (sample - first) * stream.sampleSize
+			block[index] = (block[index] &^ 0x01) | (layout>>(slot%8))&0x01
+		})
+		dataSlots.visit(first+n, count, func(slot, sample int) {
+			index := (sample - first) * stream.sampleSize
+			block[index] = (block[index] &^ mask) | slotBits(gdpFile, slot, depth)
+		})
+		hiddenSlots.visit(first+n, hiddenCount, func(slot, sample int) {
+			index := (sample - first) * stream.sampleSize
+			block[index] = (block[index] &^ mask) | slotBits(hidden.data, slot, depth)
+		})
+
+		if _, err := output.Write(block); err != nil {
+			return err
+		}
+		written += int64(len(block))
+	}
+	if layoutSlots.next < layoutCount || dataSlots.next < count || hiddenSlots.next < hiddenCount {
+		return fmt.Errorf("%w: the container ended after %d samples", ErrCapacity, written/int64(stream.sampleSize))
+	}
+
+	// Copy the chunks after the sample data
+	if stream.dataSize >= 0 {
+		_, err = io.Copy(output, stream.r)
+		return err
+	}
+
+	// Write the sizes a streamed WAV could not know, padding the data to an even size
+	if written%2 == 1 {
+		if _, err := output.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	if written > math.MaxUint32-int64(len(stream.header)) {
+		return fmt.Errorf("%w: streamed container too large for a RIFF file", ErrUnsupportedFormat)
+	}
+	riffSize := uint32(int64(len(stream.header)) + written + written%2 - 8)
+	if err := writeAt(output, 4, riffSize); err != nil {
+		return err
+	}
+	if err := writeAt(output, int64(len(stream.header))-4, uint32(written)); err != nil {
+		return err
+	}
+	_, err = output.Seek(0, io.SeekEnd)
+	return err
+}
+
+// writeAt overwrites a little-endian uint32 at the given offset.
+func writeAt(output io.WriteSeeker, offset int64, value uint32) error {
+	if _, err := output.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	return binary.Write(output, binary.LittleEndian, value)
+}
+
+// errScatteredNoPassword reports that the GDP file cannot be located without the password.
+var errScatteredNoPassword = errors.New("container uses scattered placement: a password is required")
+
+// gdpLocation describes where a GDP file sits in a carrier.
+type gdpLocation struct {
+	depth     int
+	scattered bool
+	offset    int  // Sample carrying the first bit, 0 for old containers without a layout byte
+	fec       int  // Parity bytes per error correction block, 0 without error correction
+	corrected int  // Bytes fixed by error correction
+	split     bool // The data is a piece of a split set rather than a whole GDP file
+	end       int  // Sample after the last one carrying a sequential frame, 0 when unknown
+
+	// Sync blocks: their number and the zero-based numbers of those not found
+	sync    bool
+	blocks  int
+	missing []int
+}
+
+// errNotEnoughPCM reports a frame whose size runs past the end of the carrier.
+var errNotEnoughPCM = errors.New("not enough PCM data to extract the full GDP file")
+
+// frameSize returns the number of bytes of the frame starting at data, or
+// errGDPTruncated when data is too short to tell.
+type frameSize func(data []byte) (int, error)
+
+// gdpFrameSize returns the size of a whole GDP file or, with headerOnly, of its header.
+func gdpFrameSize(headerOnly bool) frameSize {
+	return func(data []byte) (int, error) {
+		gdp, err := ParseGDPFile(data, true)
+		if err != nil {
+			return 0, err
+		}
+		if headerOnly {
+			return gdp.HeaderSize, nil
+		}
+		if gdp.CiphertextSize > uint64(math.MaxInt-gdp.HeaderSize) {
+			return 0, errNotEnoughPCM
+		}
+		return gdp.HeaderSize + int(gdp.CiphertextSize), nil
+	}
+}
+
+// extractGDP reads the GDP file, or the framed piece of one, hidden in a
+// carrier whose header has been read. A depth of 0 uses the depth recorded in
+// the carrier. With headerOnly set, reading stops after the GDP header, or
+// the piece header, unless error correction needs the whole frame.
+func extractGDP(stream *wavStream, depth int, placementSeed []byte, headerOnly bool, logf func(string, ...any)) ([]byte, gdpLocation, error) {
+	metadata := stream.metadata
+	debugf(logf, "Container format: %d, %d-bit, %d channels, %d Hz",
+		metadata.AudioFormat, metadata.BitDepth, metadata.NumChans, metadata.SampleRate)
+
+	// The layout byte sits in the first samples
+	var location gdpLocation
+	block, first, n, err := stream.nextBlock()
+	if err == io.EOF {
+		return nil, location, fmt.Errorf("%w: container too short to hold a GDP file", ErrBadMagic)
+	}
+	if err != nil {
+		return nil, location, err
+	}
+	requested := depth
+	layout, offset, err := readLayout(block[:n*stream.sampleSize], stream.sampleSize)
+	if depth == 0 {
+		if err == nil {
+			depth = int(layout & layoutDepthMask)
+		}
+	} else if err := ValidateDepth(depth); err != nil {
+		return nil, location, err
+	} else {
+		offset, err = layoutSize, nil
+	}
+	location = gdpLocation{depth: depth, scattered: layout&layoutScattered != 0, offset: offset}
+
+	var data []byte
+	consumed := false
+	switch {
+	case err != nil:
+	case layout&layoutSync != 0:
+		data, layout, err = gatherSync(stream, block, first, n, []int{depth}, &location)
+		if errors.Is(err, errNoSync) {
+			err = fmt.Errorf("%w: %v", ErrBadMagic, err)
+		}
+	default:
+		consumed = true
+		size := gdpFrameSize(headerOnly)
+		if layout&layoutSplit != 0 {
+			size = pieceFrameSize(headerOnly)
+		}
+		if layout&layoutFEC != 0 {
+			size = fecFrameSize
+		}
+		if location.scattered {
+			data, err = gatherScatteredFrame(stream, offset, depth, placementSeed, size)
+		} else {
+			data, err = gatherSequential(stream, block, first, n, offset, depth, size)
+			if !headerOnly {
+				location.end = offset + slotCount(len(data), depth)
+			}
+		}
+		if layout&layoutSplit != 0 && errors.Is(err, errNotEnoughPCM) {
+			// A piece longer than the carrier can hold is no piece at all
+			err = fmt.Errorf("%w: split piece longer than the container", ErrBadMagic)
+		}
+	}
+
+	// A trimmed carrier has lost its layout byte, look for sync blocks instead
+	if (errors.Is(err, ErrBadMagic) || errors.Is(err, errScatteredNoPassword)) && layout&layoutSync == 0 {
+		depths := []int{MinDepth, 2, 3, MaxDepth}
+		if requested != 0 {
+			depths = []int{requested}
+		}
+
+		// Only the first block has been read when the layout byte is bad, a pipe can still be scanned
+		var scanErr error
+		if consumed {
+			if scanErr = stream.rewind(); scanErr == nil {
+				block, first, n, scanErr = stream.nextBlock()
+			}
+		}
+		if scanErr == nil {
+			debugf(logf, "No GDP file at the start, scanning for sync blocks.")
+			var scanned gdpLocation
+			frame, frameLayout, scanErr := gatherSync(stream, block, first, n, depths, &scanned)
+			if !errors.Is(scanErr, errNoSync) {
+				data, layout, location, err = frame, frameLayout, scanned, scanErr
+			}
+		}
+	}
+	if err != nil {
+		return nil, location, err
+	}
+	if location.sync {
+		debugf(logf, "Found sync blocks at depth %d from sample %d, %d of %d blocks missing",
+			location.depth, location.offset, len(location.missing), location.blocks)
+		if len(location.missing) > 0 && layout&layoutFEC == 0 && !(headerOnly && location.missing[0] > 0) {
+			return nil, location, missingError(location.missing, location.blocks)
+		}
+	}
+
+	if layout&layoutFEC != 0 {
+		// Repair the frame and unwrap the GDP file
+		data, location.fec, location.corrected, err = decodeFEC(data)
+		if err != nil {
+			if len(location.missing) > 0 {
+				return nil, location, fmt.Errorf("%w (%v)", missingError(location.missing, location.blocks), err)
+			}
+			return nil, location, err
+		}
+		debugf(logf, "Error correction: %d parity bytes per block, %d bytes corrected", location.fec, location.corrected)
+	}
+	location.split = layout&layoutSplit != 0
+	if headerOnly && (location.sync || location.fec != 0) {
+		size := gdpFrameSize(true)
+		if location.split {
+			size = pieceFrameSize(true)
+		}
+		headerSize, err := size(data)
+		if err != nil {
+			return nil, location, err
+		}
+		if location.fec == 0 && len(location.missing) > 0 && location.missing[0] < syncBlocks(headerSize) {
+			return nil, location, missingError(location.missing, location.blocks)
+		}
+		data = data[:headerSize]
+	}
+	return data, location, nil
+}
+
+// gatherScatteredFrame reads a frame stored with scattered placement, making
+// passes over the sample data until its size is known and then once more.
+func gatherScatteredFrame(stream *wavStream, offset int, depth int, placementSeed []byte, size frameSize) ([]byte, error) {
+	if placementSeed == nil {
+		return nil, errScatteredNoPassword
+	}
+
+	samples := stream.samples()
+	if samples < 0 {
+		return nil, errors.New("scattered placement needs a container of known length")
+	}
+	available := (samples - offset) * depth / 8
+	if available < gdpMinSizeV1 {
+		return nil, fmt.Errorf("%w: container too short to hold a GDP file", ErrBadMagic)
+	}
+
+	// Read a first guess of the header and grow it until the whole header fits
+	headerSize := min(1024, available)
+	var header []byte
+	var total int
+	for {
+		var err error
+		header, err = gatherScattered(stream, placementSeed, offset, samples, headerSize, depth)
+		if err != nil {
+			return nil, err
+		}
+		total, err = size(header)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errGDPTruncated) || headerSize == available {
+			return nil, err
+		}
+		headerSize = min(headerSize*2, available)
+	}
+
+	// Ensure the PCM data contains enough bits
+	if total > available {
+		return nil, errNotEnoughPCM
+	}
+	if total <= len(header) {
+		return header[:total], nil
+	}
+	return gatherScattered(stream, placementSeed, offset, samples, total, depth)
+}
+
+// gatherSequential reads a frame stored in order from offset, starting with
+// a block already read, and stops reading once the whole frame is in.
+func gatherSequential(stream *wavStream, block []byte, first int, n int, offset int, depth int, size frameSize) ([]byte, error) {
+	schedule := newSlotSchedule(offset, math.MaxInt, nil, 0)
+	var data []byte
+	total := -1
+	for {
+		schedule.visit(first+n, math.MaxInt, func(slot, sample int) {
+			for (slot*depth+depth-1)/8 >= len(data) {
+				data = append(data, 0)
+			}
+			index := (sample - first) * stream.sampleSize
+			putSlotBits(data, slot, depth, block[index])
+		})
+
+		// Work out the size once enough of the header is in
+		complete := schedule.next * depth / 8
+		if total < 0 {
+			var err error
+			total, err = size(data[:min(complete, len(data))])
+			if err != nil && !errors.Is(err, errGDPTruncated) {
+				return nil, err
+			}
+			if err != nil {
+				total = -1
+			}
+		}
+		if total >= 0 && complete >= total {
+			return data[:total], nil
+		}
+
+		var err error
+		block, first, n, err = stream.nextBlock()
+		if err == io.EOF {
+			if total < 0 {
+				return nil, fmt.Errorf("%w: container too short to hold a GDP file", ErrBadMagic)
+			}
+			return nil, errNotEnoughPCM
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// gatherScattered makes a pass over the whole sample data and reads the first
+// size bytes of a GDP file stored with scattered placement from offset on.
+func gatherScattered(stream *wavStream, seed []byte, offset int, samples int, size int, depth int) ([]byte, error) {
+	if err := stream.rewind(); err != nil {
+		return nil, err
+	}
+
+	count := slotCount(size, depth)
+	schedule := newSlotSchedule(offset, count, seed, samples)
+	data := make([]byte, size)
+	for schedule.next < count {
+		block, first, n, err := stream.nextBlock()
+		if err == io.EOF {
+			return nil, errNotEnoughPCM
+		}
+		if err != nil {
+			return nil, err
+		}
+		schedule.visit(first+n, count, func(slot, sample int) {
+			index := (sample - first) * stream.sampleSize
+			putSlotBits(data, slot, depth, block[index])
+		})
+	}
+
+	return data, nil
+}
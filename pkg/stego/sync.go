@@ -0,0 +1,276 @@
+package stego
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Sync blocks
+// ---------------------------------------------------------
+// Sync marker : 8 bytes (a4 1f 6b d2 39 c5 0e 97)
+// Sequence    : 4 bytes (uint32), block number from 0
+// Frame size  : 4 bytes (uint32), bytes in all blocks together
+// Layout      : 1 byte, the layout byte of the container
+// CRC32       : 4 bytes (uint32), IEEE CRC of the fields above after the
+//               marker and of the data
+// Data        : 512 bytes, fewer in the last block
+// ---------------------------------------------------------
+//
+// With sync blocks the layout byte has layoutSync set and the LSB stream is
+// the GDP file, or its error-correcting frame, cut into blocks that can each
+// be recognised on their own. The blocks holding the header are written a
+// second time after the last one. Extraction scans the bits at every depth
+// for the marker, so a carrier that lost its start, its end or a stretch in
+// between still gives up the blocks that remain. Missing blocks are fatal
+// unless error correction can fill them in.
+
+const (
+	syncBlockData  = 512
+	syncHeaderSize = 21
+)
+
+var syncMarker = [8]byte{0xa4, 0x1f, 0x6b, 0xd2, 0x39, 0xc5, 0x0e, 0x97}
+
+// ErrIncomplete means parts of the payload are missing from the carrier.
+var ErrIncomplete = errors.New("incomplete payload")
+
+// errNoSync reports that a scan found no sync blocks at all.
+var errNoSync = errors.New("no sync blocks found")
+
+// syncBlocks returns the number of sync blocks for a frame of size bytes.
+func syncBlocks(size int) int {
+	return (size + syncBlockData - 1) / syncBlockData
+}
+
+// SyncSize returns the number of bytes a frame of size bytes takes as sync
+// blocks, with the first headerSize bytes written twice.
+func SyncSize(size int, headerSize int) int {
+	repeated := syncBlocks(min(headerSize, size))
+	return (syncBlocks(size)+repeated)*syncHeaderSize + size + min(repeated*syncBlockData, size)
+}
+
+// encodeSync cuts a frame into sync blocks and repeats the blocks holding
+// its first headerSize bytes at the end.
+func encodeSync(frame []byte, layout byte, headerSize int) []byte {
+	blocks := syncBlocks(len(frame))
+	out := make([]byte, 0, SyncSize(len(frame), headerSize))
+	for seq := 0; seq < blocks+syncBlocks(min(headerSize, len(frame))); seq++ {
+		index := seq % blocks
+		data := frame[index*syncBlockData : min((index+1)*syncBlockData, len(frame))]
+
+		header := make([]byte, syncHeaderSize)
+		copy(header, syncMarker[:])
+		binary.LittleEndian.PutUint32(header[8:], uint32(index))
+		binary.LittleEndian.PutUint32(header[12:], uint32(len(frame)))
+		header[16] = layout
+		crc := crc32.NewIEEE()
+		crc.Write(header[8:17])
+		crc.Write(data)
+		binary.LittleEndian.PutUint32(header[17:], crc.Sum32())
+
+		out = append(out, header...)
+		out = append(out, data...)
+	}
+	return out
+}
+
+// syncBlock is a sync block read back from a carrier.
+type syncBlock struct {
+	seq    int
+	size   int
+	layout byte
+	sample int // Sample carrying the first bit of the marker
+	data   []byte
+}
+
+// syncCollector gathers the bits following a marker.
+type syncCollector struct {
+	sample int
+	buf    []byte
+	bits   int
+	want   int // Bytes expected after the marker, known once the header is in
+}
+
+// syncScanner looks for sync blocks in the bits at one depth.
+type syncScanner struct {
+	depth     int
+	available int // Bytes the carrier can hold at this depth, -1 when unknown
+	register  uint64
+	seen      int
+	pending   []*syncCollector
+	blocks    map[int]*syncBlock
+	size      int // Frame size agreed by the first valid block, -1 before
+}
+
+var syncMarkerValue = binary.LittleEndian.Uint64(syncMarker[:])
+
+// feed passes the LSBs of one sample through the scanner.
+func (s *syncScanner) feed(value byte, sample int) {
+	for bit := 0; bit < s.depth; bit++ {
+		b := (value >> bit) & 0x01
+
+		// Collectors started at earlier markers take the bit first
+		kept := s.pending[:0]
+		for _, c := range s.pending {
+			c.buf[c.bits/8] |= b << (c.bits % 8)
+			c.bits++
+			if s.advance(c) {
+				kept = append(kept, c)
+			}
+		}
+		s.pending = kept
+
+		s.register = s.register>>1 | uint64(b)<<63
+		s.seen++
+		if s.seen >= 64 && s.register == syncMarkerValue {
+			first := sample - (64-bit-1+s.depth-1)/s.depth
+			s.pending = append(s.pending, &syncCollector{sample: first, buf: make([]byte, syncHeaderSize-8+syncBlockData)})
+		}
+	}
+}
+
+// advance checks a collector after a new bit and reports whether it needs more.
+func (s *syncScanner) advance(c *syncCollector) bool {
+	if c.bits%8 != 0 {
+		return true
+	}
+	n := c.bits / 8
+	if n == syncHeaderSize-8 {
+		seq := int(binary.LittleEndian.Uint32(c.buf[0:4]))
+		size := int(binary.LittleEndian.Uint32(c.buf[4:8]))
+		if size == 0 || seq >= syncBlocks(size) || (s.available >= 0 && size > s.available) {
+			return false
+		}
+		c.want = syncHeaderSize - 8 + min(syncBlockData, size-seq*syncBlockData)
+	}
+	if c.want == 0 || n < c.want {
+		return true
+	}
+
+	// The block is complete, keep it when its CRC holds and it agrees with the others
+	header, data := c.buf[:syncHeaderSize-8], c.buf[syncHeaderSize-8:c.want]
+	crc := crc32.NewIEEE()
+	crc.Write(header[:9])
+	crc.Write(data)
+	if crc.Sum32() != binary.LittleEndian.Uint32(header[9:13]) {
+		return false
+	}
+	block := &syncBlock{
+		seq:    int(binary.LittleEndian.Uint32(header[0:4])),
+		size:   int(binary.LittleEndian.Uint32(header[4:8])),
+		layout: header[8],
+		sample: c.sample,
+		data:   append([]byte(nil), data...),
+	}
+	if s.size < 0 {
+		s.size = block.size
+	}
+	if _, ok := s.blocks[block.seq]; !ok && block.size == s.size {
+		s.blocks[block.seq] = block
+	}
+	return false
+}
+
+// complete reports whether every block of the frame has been found.
+func (s *syncScanner) complete() bool {
+	return s.size >= 0 && len(s.blocks) == syncBlocks(s.size)
+}
+
+// gatherSync reads the rest of the carrier, starting with a block already
+// read, collects the sync blocks at each of the given depths and reassembles
+// the frame. Missing blocks are left as zeros and listed in the location.
+func gatherSync(stream *wavStream, block []byte, first int, n int, depths []int, location *gdpLocation) ([]byte, byte, error) {
+	samples := stream.samples()
+	scanners := make([]*syncScanner, len(depths))
+	for i, depth := range depths {
+		available := -1
+		if samples >= 0 {
+			available = samples * depth / 8
+		}
+		scanners[i] = &syncScanner{depth: depth, available: available, blocks: make(map[int]*syncBlock), size: -1}
+	}
+
+	for {
+		for i := 0; i < n; i++ {
+			value := block[i*stream.sampleSize]
+			for _, s := range scanners {
+				s.feed(value, first+i)
+			}
+		}
+		done := false
+		for _, s := range scanners {
+			done = done || s.complete()
+		}
+		if done {
+			break
+		}
+
+		var err error
+		block, first, n, err = stream.nextBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// Only the depth the data was written at finds valid blocks
+	var best *syncScanner
+	for _, s := range scanners {
+		if best == nil || len(s.blocks) > len(best.blocks) {
+			best = s
+		}
+	}
+	if len(best.blocks) == 0 {
+		return nil, 0, errNoSync
+	}
+
+	frame := make([]byte, best.size)
+	location.depth, location.sync, location.offset = best.depth, true, -1
+	var layout byte
+	for seq := 0; seq < syncBlocks(best.size); seq++ {
+		b, ok := best.blocks[seq]
+		if !ok {
+			location.missing = append(location.missing, seq)
+			continue
+		}
+		copy(frame[seq*syncBlockData:], b.data)
+		layout = b.layout
+		if location.offset < 0 || b.sample < location.offset {
+			location.offset = b.sample
+		}
+	}
+	location.blocks = syncBlocks(best.size)
+	return frame, layout, nil
+}
+
+// missingError describes the blocks missing from a frame.
+func missingError(missing []int, blocks int) error {
+	return fmt.Errorf("%w: blocks %s of %d are missing", ErrIncomplete, FormatBlocks(missing), blocks)
+}
+
+// FormatBlocks lists zero-based block numbers as one-based ranges, such as "1-3, 7".
+func FormatBlocks(numbers []int) string {
+	sort.Ints(numbers)
+	var parts []string
+	for i := 0; i < len(numbers); {
+		j := i
+		for j+1 < len(numbers) && numbers[j+1] == numbers[j]+1 {
+			j++
+		}
+		part := strconv.Itoa(numbers[i] + 1)
+		if j > i {
+			part += "-" + strconv.Itoa(numbers[j]+1)
+		}
+		parts = append(parts, part)
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,97 @@
+package stego
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"slices"
+	"testing"
+)
+
+// testCarrier returns a 16-bit mono WAV file of noise samples whose LSBs, from
+// sample lead on, carry data at the given depth.
+func testCarrier(data []byte, depth int, lead int) []byte {
+	samples := lead + slotCount(len(data), depth) + 500
+	pcm := randomBytes(samples*2, 21)
+	for i := 0; i < len(data)*8; i++ {
+		sample := 2 * (lead + i/depth)
+		bit := uint(i % depth)
+		pcm[sample] = pcm[sample]&^(1<<bit) | (data[i/8]>>(i%8)&0x01)<<bit
+	}
+	return testWAV(pcm)
+}
+
+// testWAV wraps 16-bit mono sample data in a WAV header.
+func testWAV(pcm []byte) []byte {
+	var wav bytes.Buffer
+	wav.WriteString("RIFF")
+	binary.Write(&wav, binary.LittleEndian, uint32(36+len(pcm)))
+	wav.WriteString("WAVEfmt ")
+	for _, field := range []any{uint32(16), uint16(1), uint16(1), uint32(8000), uint32(16000), uint16(2), uint16(16)} {
+		binary.Write(&wav, binary.LittleEndian, field)
+	}
+	wav.WriteString("data")
+	binary.Write(&wav, binary.LittleEndian, uint32(len(pcm)))
+	wav.Write(pcm)
+	return wav.Bytes()
+}
+
+// scanSync reads a carrier and scans it for sync blocks at every depth.
+func scanSync(t *testing.T, wav []byte) ([]byte, byte, gdpLocation, error) {
+	stream, err := readWAVHeader(bytes.NewReader(wav), 0)
+	if err != nil {
+		t.Fatalf("readWAVHeader: %v", err)
+	}
+	block, first, n, err := stream.nextBlock()
+	if err != nil {
+		t.Fatalf("nextBlock: %v", err)
+	}
+	var location gdpLocation
+	frame, layout, err := gatherSync(stream, block, first, n, []int{MinDepth, 2, 3, MaxDepth}, &location)
+	return frame, layout, location, err
+}
+
+// TestSyncScan finds sync blocks wherever they start and reports the lost ones.
+func TestSyncScan(t *testing.T) {
+	const depth, lead, headerSize = 2, 1000, 100
+	layout := byte(depth) | layoutSync
+	frame := randomBytes(3*syncBlockData+200, 22)
+	encoded := encodeSync(frame, layout, headerSize)
+	if len(encoded) != SyncSize(len(frame), headerSize) {
+		t.Fatalf("%d bytes of sync blocks, SyncSize says %d", len(encoded), SyncSize(len(frame), headerSize))
+	}
+	wav := testCarrier(encoded, depth, lead)
+	const pcmStart = 44
+	blockSamples := slotCount(syncHeaderSize+syncBlockData, depth)
+
+	// The blocks are found behind any number of samples, at the depth they were written at
+	got, gotLayout, location, err := scanSync(t, wav)
+	if err != nil || !bytes.Equal(got, frame) || gotLayout != layout || location.depth != depth ||
+		location.offset != lead || len(location.missing) != 0 || location.blocks != 4 {
+		t.Fatalf("clean carrier: layout %#x, %+v, %v", gotLayout, location, err)
+	}
+
+	// A trimmed start loses the first block, whose copy at the end stands in for it
+	trimmed := testWAV(slices.Clone(wav[pcmStart+2*(lead+50):]))
+	got, _, location, err = scanSync(t, trimmed)
+	if err != nil || !bytes.Equal(got, frame) || len(location.missing) != 0 {
+		t.Fatalf("trimmed carrier: %+v, %v", location, err)
+	}
+
+	// A cut through the second block loses it
+	cutStart, cutEnd := pcmStart+2*(lead+blockSamples+10), pcmStart+2*(lead+blockSamples+500)
+	cut := testWAV(append(slices.Clone(wav[pcmStart:cutStart]), wav[cutEnd:]...))
+	got, _, location, err = scanSync(t, cut)
+	if err != nil || !slices.Equal(location.missing, []int{1}) ||
+		!bytes.Equal(got[:syncBlockData], frame[:syncBlockData]) || !bytes.Equal(got[2*syncBlockData:], frame[2*syncBlockData:]) {
+		t.Fatalf("cut carrier: %+v, %v", location, err)
+	}
+	if err := missingError(location.missing, location.blocks); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("missingError: got %v, want ErrIncomplete", err)
+	}
+
+	// Noise holds no blocks
+	if _, _, _, err := scanSync(t, testWAV(randomBytes(40000, 23))); !errors.Is(err, errNoSync) {
+		t.Fatalf("noise: got %v, want errNoSync", err)
+	}
+}
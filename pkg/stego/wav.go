@@ -0,0 +1,225 @@
+package stego
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// WAV audio format codes from the fmt chunk.
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+type wavMetadata struct {
+	SampleRate  uint32
+	BitDepth    uint16
+	NumChans    uint16
+	AudioFormat uint16
+	BlockAlign  uint16
+}
+
+// SampleSize returns the size in bytes of a single sample.
+func (m wavMetadata) SampleSize() int {
+	return int(m.BlockAlign / m.NumChans)
+}
+
+// parseFmtChunk decodes a fmt chunk and checks that GoDeep can use its samples as a carrier.
+func parseFmtChunk(chunk []byte) (*wavMetadata, error) {
+	if len(chunk) < 16 {
+		return nil, fmt.Errorf("%w: fmt chunk too short", ErrUnsupportedFormat)
+	}
+
+	metadata := &wavMetadata{
+		AudioFormat: binary.LittleEndian.Uint16(chunk[0:2]),
+		NumChans:    binary.LittleEndian.Uint16(chunk[2:4]),
+		SampleRate:  binary.LittleEndian.Uint32(chunk[4:8]),
+		BlockAlign:  binary.LittleEndian.Uint16(chunk[12:14]),
+		BitDepth:    binary.LittleEndian.Uint16(chunk[14:16]),
+	}
+
+	// WAVE_FORMAT_EXTENSIBLE keeps the real format in the first two bytes of the sub-format GUID
+	if metadata.AudioFormat == wavFormatExtensible {
+		if len(chunk) < 40 {
+			return nil, fmt.Errorf("%w: extensible fmt chunk too short", ErrUnsupportedFormat)
+		}
+		metadata.AudioFormat = binary.LittleEndian.Uint16(chunk[24:26])
+	}
+
+	if metadata.NumChans == 0 {
+		return nil, fmt.Errorf("%w: no channels", ErrUnsupportedFormat)
+	}
+
+	switch metadata.AudioFormat {
+	case wavFormatPCM:
+		switch metadata.BitDepth {
+		case 8, 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("%w: PCM bit depth %d", ErrUnsupportedFormat, metadata.BitDepth)
+		}
+	case wavFormatFloat:
+		switch metadata.BitDepth {
+		case 32, 64:
+		default:
+			return nil, fmt.Errorf("%w: IEEE float bit depth %d", ErrUnsupportedFormat, metadata.BitDepth)
+		}
+	default:
+		return nil, fmt.Errorf("%w: WAV audio format %d", ErrUnsupportedFormat, metadata.AudioFormat)
+	}
+
+	if int(metadata.BlockAlign) != int(metadata.NumChans)*int(metadata.BitDepth)/8 {
+		return nil, fmt.Errorf("%w: block align %d does not match %d channels of %d bits", ErrUnsupportedFormat,
+			metadata.BlockAlign, metadata.NumChans, metadata.BitDepth)
+	}
+
+	return metadata, nil
+}
+
+// LSB Stream Layout
+// ---------------------------------------------------------
+// Layout   : 24 samples, 1 bit each, the layout byte three times
+//            bits 0-2 depth (1-4), bit 4 split set, bit 5 sync blocks,
+//            bit 6 error correction, bit 7 scattered placement
+// GDP file : remaining samples, <Depth> bits each
+// ---------------------------------------------------------
+//
+// Every flag of the layout byte changes how the rest is read, and error
+// correction only covers what follows it, so the byte is written three times
+// and read back by a bitwise majority vote. One flipped bit in each of its
+// bit positions is outvoted.
+//
+// Only the least significant byte of each little-endian sample is touched,
+// whether the sample is 8/16/24/32-bit integer PCM or IEEE float, where the
+// bits land in the low end of the mantissa.
+// With scattered placement the GDP file does not fill the samples in order:
+// a keyed pseudo-random permutation of the remaining samples, seeded from the
+// password, decides which sample carries each group of bits.
+// Containers written before the sample-aware layout used every PCM byte as a
+// carrier; they are read back with a sample size of LegacySampleSize. The
+// oldest of those start directly with the GDP magic instead of a layout byte,
+// which readLayout still recognises.
+
+const (
+	MinDepth = 1
+	MaxDepth = 4
+
+	// LegacySampleSize treats every PCM byte as a sample, as older GoDeep versions did.
+	LegacySampleSize = 1
+
+	// layoutCopies is the number of times the layout byte is written.
+	layoutCopies = 3
+	// layoutSize is the number of samples holding the layout byte copies.
+	layoutSize = 8 * layoutCopies
+
+	layoutDepthMask = 0x07
+	layoutSplit     = 0x10 // The data is one piece of a set split across containers
+	layoutSync      = 0x20 // The data is cut into sync blocks
+	layoutFEC       = 0x40 // The data is wrapped in an error-correcting frame
+	layoutScattered = 0x80
+)
+
+// ValidateDepth checks that depth is a supported number of LSBs per sample.
+func ValidateDepth(depth int) error {
+	if depth < MinDepth || depth > MaxDepth {
+		return fmt.Errorf("invalid embedding depth %d: must be between %d and %d", depth, MinDepth, MaxDepth)
+	}
+	return nil
+}
+
+// GetSampleCount returns the number of carrier samples in PCM data.
+func GetSampleCount(pcmData []byte, sampleSize int) int {
+	return len(pcmData) / sampleSize
+}
+
+// readLayout reads the layout byte and returns it with the sample offset of
+// the GDP file. Legacy containers without a layout byte read as depth 1.
+func readLayout(pcmData []byte, sampleSize int) (byte, int, error) {
+	if GetSampleCount(pcmData, sampleSize) < layoutSize {
+		return 0, 0, fmt.Errorf("%w: container too short to hold a GDP file", ErrBadMagic)
+	}
+
+	copies := readLSB(pcmData, sampleSize, sequentialPlacement(0), layoutCopies, 1)
+	if string(copies) == "GDP" {
+		// Legacy container without a layout byte
+		return 1, 0, nil
+	}
+	layout := copies[0]&copies[1] | copies[0]&copies[2] | copies[1]&copies[2]
+	if layout&^(layoutDepthMask|layoutSplit|layoutSync|layoutFEC|layoutScattered) != 0 {
+		return 0, 0, fmt.Errorf("%w, unknown layout %#x", ErrBadMagic, layout)
+	}
+	if err := ValidateDepth(int(layout & layoutDepthMask)); err != nil {
+		return 0, 0, fmt.Errorf("%w, %v", ErrBadMagic, err)
+	}
+
+	return layout, layoutSize, nil
+}
+
+// placement maps the n-th group of depth bits to the sample that carries it.
+type placement func(slot int) int
+
+// slotCount returns the number of samples needed to hold size bytes at the given depth.
+func slotCount(size int, depth int) int {
+	return (size*8 + depth - 1) / depth
+}
+
+// sequentialPlacement fills samples in order, starting at offset.
+func sequentialPlacement(offset int) placement {
+	return func(slot int) int {
+		return offset + slot
+	}
+}
+
+// scatteredPlacement picks the samples for the first count slots from a keyed
+// pseudo-random permutation of every sample from offset on, which is the end
+// of the layout byte unless another frame comes first. The permutation is a
+// Fisher-Yates shuffle that only tracks the swapped entries, so memory grows
+// with count rather than with the container.
+func scatteredPlacement(seed []byte, offset int, samples int, count int) placement {
+	rng := rand.NewChaCha8(sha256.Sum256(seed))
+	size := samples - offset
+
+	swapped := make(map[int]int, count)
+	positions := make([]int, count)
+	for i := 0; i < count; i++ {
+		j := i + uniformInt(rng, size-i)
+		value, ok := swapped[j]
+		if !ok {
+			value = j
+		}
+		if current, ok := swapped[i]; ok {
+			swapped[j] = current
+		} else {
+			swapped[j] = i
+		}
+		positions[i] = offset + value
+	}
+
+	return func(slot int) int {
+		return positions[slot]
+	}
+}
+
+// uniformInt returns an unbiased value in [0, n) from the generator's raw
+// output, so placement does not depend on math/rand's derivation helpers.
+func uniformInt(rng *rand.ChaCha8, n int) int {
+	limit := math.MaxUint64 - math.MaxUint64%uint64(n)
+	for {
+		if v := rng.Uint64(); v < limit {
+			return int(v % uint64(n))
+		}
+	}
+}
+
+// readLSB collects size bytes from the lowest depth bits of the samples chosen by positions.
+func readLSB(pcmData []byte, sampleSize int, positions placement, size int, depth int) []byte {
+	data := make([]byte, size)
+	for i := 0; i < size*8; i++ {
+		index := positions(i/depth) * sampleSize
+		data[i/8] |= ((pcmData[index] >> (i % depth)) & 0x01) << (i % 8)
+	}
+	return data
+}
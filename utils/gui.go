@@ -8,6 +8,10 @@ import (
 	"fyne.io/fyne/v2/dialog"
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
+	"strconv"
+	"strings"
+
+	"github.com/sarange/godeep/pkg/stego"
 )
 
 // SpawnGui initializes and runs the GoDeep GUI
@@ -39,23 +43,37 @@ func SpawnGui() {
 	})
 	containerBox := container.NewBorder(nil, nil, nil, containerButton, containerEntry)
 
-	// Input File Selection
-	inputLabel := widget.NewLabel("File to embed:")
-	inputEntry := widget.NewEntry()
-	inputEntry.SetPlaceHolder("Select input file")
-	inputButton := widget.NewButton("Browse", func() {
+	// Input Files Selection, one path per line
+	inputLabel := widget.NewLabel("Files or folders to embed:")
+	inputEntry := widget.NewMultiLineEntry()
+	inputEntry.SetPlaceHolder("Select input files or folders")
+	inputEntry.SetMinRowsVisible(2)
+	addInput := func(path string) {
+		if inputEntry.Text != "" && !strings.HasSuffix(inputEntry.Text, "\n") {
+			path = "\n" + path
+		}
+		inputEntry.SetText(inputEntry.Text + path)
+	}
+	inputButton := widget.NewButton("Add File", func() {
 		dialog.ShowFileOpen(func(f fyne.URIReadCloser, err error) {
 			if f != nil {
-				inputEntry.SetText(f.URI().Path())
+				addInput(f.URI().Path())
+			}
+		}, win)
+	})
+	inputFolderButton := widget.NewButton("Add Folder", func() {
+		dialog.ShowFolderOpen(func(f fyne.ListableURI, err error) {
+			if f != nil {
+				addInput(f.Path())
 			}
 		}, win)
 	})
-	inputBox := container.NewBorder(nil, nil, nil, inputButton, inputEntry)
+	inputBox := container.NewBorder(nil, nil, nil, container.NewVBox(inputButton, inputFolderButton), inputEntry)
 
 	// Output File Selection
 	outputLabel := widget.NewLabel("Output File:")
 	outputEntry := widget.NewEntry()
-	outputEntry.SetPlaceHolder("Select output file")
+	outputEntry.SetPlaceHolder("Select output file (or a folder when extracting)")
 	outputButton := widget.NewButton("Browse", func() {
 		dialog.ShowFileSave(func(f fyne.URIWriteCloser, err error) {
 			if f != nil {
@@ -70,18 +88,55 @@ func SpawnGui() {
 	passwordEntry := widget.NewPasswordEntry()
 	passwordEntry.SetPlaceHolder("Enter password")
 
+	// Embedding Depth
+	depthLabel := widget.NewLabel("Embedding Depth (LSBs per sample):")
+	depthSelect := widget.NewSelect([]string{"1", "2", "3", "4"}, nil)
+	depthSelect.SetSelected("1") // Default selection
+
+	// Scattered Placement (Embed only)
+	scatterCheck := widget.NewCheck("Scatter data across the whole file (needs password)", nil)
+
+	// Key Derivation (Embed only), empty cost fields keep the KDF's defaults
+	kdfLabel := widget.NewLabel("Key Derivation:")
+	kdfSelect := widget.NewSelect([]string{"pbkdf2", "argon2id", "scrypt"}, nil)
+	kdfTimeEntry := widget.NewEntry()
+	kdfMemoryEntry := widget.NewEntry()
+	kdfThreadsEntry := widget.NewEntry()
+	kdfSelect.OnChanged = func(selected string) {
+		defaults, _ := stego.NewKDFParams(selected)
+		kdfTimeEntry.SetPlaceHolder(fmt.Sprintf("Time (%d)", defaults.Time))
+		kdfMemoryEntry.SetPlaceHolder(fmt.Sprintf("Memory (%dKiB)", defaults.Memory))
+		kdfThreadsEntry.SetPlaceHolder(fmt.Sprintf("Threads (%d)", defaults.Threads))
+	}
+	kdfSelect.SetSelected("pbkdf2") // Default selection
+	kdfBox := container.NewGridWithColumns(3, kdfTimeEntry, kdfMemoryEntry, kdfThreadsEntry)
+
+	// Legacy Layout (Extract only)
+	legacyCheck := widget.NewCheck("Container made by an older GoDeep version", nil)
+	preserveCheck := widget.NewCheck("Restore original permissions and modification time", nil)
+	preserveCheck.SetChecked(true)
+
 	// Progress Bar & Status
 	progress := widget.NewProgressBar()
 	statusLabel := widget.NewLabel("Ready")
 
-	// Dynamically show/hide input file field based on mode
+	// Dynamically show/hide embed and extract fields based on mode
+	embedOnly := []fyne.CanvasObject{inputLabel, inputBox, depthLabel, depthSelect, scatterCheck, kdfLabel, kdfSelect, kdfBox}
+	extractOnly := []fyne.CanvasObject{legacyCheck, preserveCheck}
 	modeSelect.OnChanged = func(selected string) {
-		if selected == "Embed" {
-			inputLabel.Show()
-			inputBox.Show()
-		} else {
-			inputLabel.Hide()
-			inputBox.Hide()
+		for _, object := range embedOnly {
+			if selected == "Embed" {
+				object.Show()
+			} else {
+				object.Hide()
+			}
+		}
+		for _, object := range extractOnly {
+			if selected == "Embed" {
+				object.Hide()
+			} else {
+				object.Show()
+			}
 		}
 	}
 	modeSelect.OnChanged("Embed")
@@ -89,10 +144,49 @@ func SpawnGui() {
 	// Run Button - Calls Embed or Extract function directly
 	runButton := widget.NewButtonWithIcon("Run", theme.ConfirmIcon(), func() {
 		mode := modeSelect.Selected
-		input := inputEntry.Text
+		var inputs []string
+		for _, line := range strings.Split(inputEntry.Text, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				inputs = append(inputs, line)
+			}
+		}
 		output := outputEntry.Text
 		container := containerEntry.Text
 		password := passwordEntry.Text
+		depth, _ := strconv.Atoi(depthSelect.Selected)
+		scatter := scatterCheck.Checked
+		legacy := legacyCheck.Checked
+		preserve := preserveCheck.Checked
+
+		// Apply the KDF cost overrides on top of the chosen KDF's defaults
+		kdf, err := stego.NewKDFParams(kdfSelect.Selected)
+		if err != nil {
+			dialog.ShowError(err, win)
+			return
+		}
+		if kdfTimeEntry.Text != "" {
+			time, err := strconv.ParseUint(kdfTimeEntry.Text, 10, 32)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("Invalid KDF time: %s", kdfTimeEntry.Text), win)
+				return
+			}
+			kdf.Time = uint32(time)
+		}
+		if kdfMemoryEntry.Text != "" {
+			kdf.Memory, err = stego.ParseMemorySize(kdfMemoryEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, win)
+				return
+			}
+		}
+		if kdfThreadsEntry.Text != "" {
+			threads, err := strconv.ParseUint(kdfThreadsEntry.Text, 10, 8)
+			if err != nil {
+				dialog.ShowError(fmt.Errorf("Invalid KDF threads: %s", kdfThreadsEntry.Text), win)
+				return
+			}
+			kdf.Threads = uint8(threads)
+		}
 
 		// Validate required inputs
 		if container == "" || output == "" || mode == "" {
@@ -100,29 +194,48 @@ func SpawnGui() {
 			return
 		}
 
-		if mode == "Embed" && input == "" {
-			dialog.ShowError(fmt.Errorf("Input file is required for embedding"), win)
+		if mode == "Embed" && len(inputs) == 0 {
+			dialog.ShowError(fmt.Errorf("At least one input file is required for embedding"), win)
+			return
+		}
+
+		if mode == "Embed" && scatter && password == "" {
+			dialog.ShowError(fmt.Errorf("A password is required to scatter data"), win)
 			return
 		}
 
 		progress.SetValue(0.1) // Initial progress
 		statusLabel.SetText("Processing...")
 
-		// Derive encryption key if needed
-		var key []byte
+		// Encrypt whenever a password is given
 		encryption := password != ""
-		if encryption {
-			key = DeriveKey(password)
-		}
 
 		// Run the function in a goroutine to prevent UI freezing
 		go func() {
 			var err error
 
+			// Show progress messages in the status line
+			logf := func(format string, args ...any) {
+				statusLabel.SetText(fmt.Sprintf(format, args...))
+			}
+
 			if mode == "Embed" {
-				err = Embed(input, output, container, key, encryption, true)
+				err = stego.Embed(inputs, output, container, stego.EmbedOptions{
+					Password:   password,
+					Encryption: encryption,
+					KDF:        kdf,
+					Depth:      depth,
+					Scatter:    scatter,
+					Checksum:   stego.ChecksumSHA256,
+					Logf:       logf,
+				})
 			} else {
-				err = Extract(container, output, key, encryption, true)
+				_, err = stego.Extract(container, output, stego.ExtractOptions{
+					Password:     password,
+					LegacyLayout: legacy,
+					NoPreserve:   !preserve,
+					Logf:         logf,
+				})
 			}
 
 			if err != nil {
@@ -141,6 +254,14 @@ func SpawnGui() {
 		outputEntry.SetText("")
 		containerEntry.SetText("")
 		passwordEntry.SetText("")
+		depthSelect.SetSelected("1")
+		scatterCheck.SetChecked(false)
+		legacyCheck.SetChecked(false)
+		preserveCheck.SetChecked(true)
+		kdfSelect.SetSelected("pbkdf2")
+		kdfTimeEntry.SetText("")
+		kdfMemoryEntry.SetText("")
+		kdfThreadsEntry.SetText("")
 		progress.SetValue(0)
 		statusLabel.SetText("Ready")
 	})
@@ -157,6 +278,12 @@ func SpawnGui() {
 		outputLabel, outputBox,
 
 		passwordLabel, passwordEntry,
+		container.NewGridWithColumns(2, depthLabel, depthSelect),
+		scatterCheck,
+		container.NewGridWithColumns(2, kdfLabel, kdfSelect),
+		kdfBox,
+		legacyCheck,
+		preserveCheck,
 
 		widget.NewSeparator(),
 		progress,